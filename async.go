@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Supported collection modes.
+const (
+	collectionModeSync  = "sync"
+	collectionModeAsync = "async"
+)
+
+var targetDataAgeDesc = prometheus.NewDesc(
+	"azure_exporter_target_data_age_seconds",
+	"Seconds since the metrics of the target resource were last collected successfully.",
+	[]string{"resource"}, nil,
+)
+
+// checkCollectionMode checks that the configuration can be collected in the collection mode.
+func checkCollectionMode(c *config.Config) error {
+	// Only background collections are pushed, targets are part of them in the async mode.
+	if (len(c.RemoteWrite) > 0 || c.OTLP != nil) && *collectionMode != collectionModeAsync {
+		return fmt.Errorf("remote_write and otlp require --collection.mode=%s", collectionModeAsync)
+	}
+	// Backfilled data points only reach Prometheus when pushed, scrapes export the latest ones.
+	if c.Backfill.Enabled && (*collectionMode != collectionModeAsync || (len(c.RemoteWrite) == 0 && c.OTLP == nil)) {
+		return fmt.Errorf("Backfill requires --collection.mode=%s and remote_write or otlp", collectionModeAsync)
+	}
+	return nil
+}
+
+// newTargetQueries returns background queries collecting the metrics of every target on its
+// interval. The first runs are spread evenly over the interval, to smooth the load on the API.
+func newTargetQueries(c *config.Config) []*backgroundQuery {
+	queries := make([]*backgroundQuery, 0, len(c.Targets))
+	for i, target := range c.Targets {
+		target := target
+		interval := time.Duration(target.Interval)
+		queries = append(queries, &backgroundQuery{
+			name:     fmt.Sprintf("metrics query of target %s", target.Resource),
+			interval: interval,
+			offset:   interval * time.Duration(i) / time.Duration(len(c.Targets)),
+			resource: target.Resource,
+			target:   &target,
+			// The previous samples are kept if any request fails, so a snapshot is always complete.
+			run: func() ([]sample, error) {
+				return collectTarget(c, target)
+			},
+		})
+	}
+	return queries
+}
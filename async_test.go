@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/azuretest"
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/prometheus/common/model"
+)
+
+// setCollectionMode sets the collection mode until the returned function is called.
+func setCollectionMode(mode string) func() {
+	prev := *collectionMode
+	*collectionMode = mode
+	return func() { *collectionMode = prev }
+}
+
+// dataAges removes the data age samples from the scraped samples and returns them by resource.
+func dataAges(t *testing.T, samples []string) ([]string, map[string]float64) {
+	rest := []string{}
+	ages := map[string]float64{}
+	for _, s := range samples {
+		if !strings.HasPrefix(s, "azure_exporter_target_data_age_seconds{") {
+			rest = append(rest, s)
+			continue
+		}
+		parts := strings.SplitN(s, " ", 2)
+		age, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ages[strings.TrimSuffix(strings.TrimPrefix(parts[0], `azure_exporter_target_data_age_seconds{resource="`), `"}`)] = age
+	}
+	return rest, ages
+}
+
+func TestCollectAsync(t *testing.T) {
+	defer setCollectionMode(collectionModeAsync)()
+	srv := setup(t, func(_ *azuretest.Server, c *config.Config) {
+		c.Targets[0].Aggregations = []string{"Total"}
+	}, webAppTarget("BytesReceived"))
+	defer srv.Close()
+
+	// Nothing is exported before the targets were queried.
+	assertSamples(t, scrape(t))
+	if len(srv.Requests()) != 0 {
+		t.Fatalf("Expected scrapes not to query Azure, got %d requests", len(srv.Requests()))
+	}
+
+	runBackgroundQueries()
+	want := `bytesreceived_bytes_total{resource_group="blog-group",resource_name="blog"} 2048`
+	samples, ages := dataAges(t, scrape(t))
+	assertSamples(t, samples, want)
+	if age, ok := ages[azuretest.WebAppResource]; !ok || age < 0 || age > 10 {
+		t.Errorf("Unexpected data ages %v", ages)
+	}
+	if len(srv.Requests()) != 1 {
+		t.Errorf("Expected a single metrics request, got %d", len(srv.Requests()))
+	}
+
+	// A failed run keeps the previous snapshot, which ages.
+	q := bq.queries[0]
+	q.mu.Lock()
+	q.updated = q.updated.Add(-time.Hour)
+	q.mu.Unlock()
+	srv.FailNext(1, http.StatusInternalServerError, "InternalServerError", "Something went wrong.")
+	q.update()
+	samples, ages = dataAges(t, scrape(t))
+	assertSamples(t, samples, want)
+	if age := ages[azuretest.WebAppResource]; age < 3600 {
+		t.Errorf("Expected data age of at least an hour, got %v", age)
+	}
+}
+
+func TestReloadCarriesTargetSamples(t *testing.T) {
+	defer setCollectionMode(collectionModeAsync)()
+	vm := config.Target{Resource: azuretest.VMResource, Metrics: []config.Metric{{Name: "Percentage CPU"}}}
+	srv := setup(t, nil, webAppTarget("BytesReceived"), vm)
+	defer srv.Close()
+	runBackgroundQueries()
+
+	vm.Aggregations = []string{"Average"}
+	c := srv.Config(webAppTarget("BytesReceived"), vm)
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	queries := newBackgroundQueries(c)
+	carrySamples(bq.queries, queries)
+	if len(queries[0].samples) != 4 || queries[0].updated.IsZero() {
+		t.Errorf("Expected the samples of the unchanged target to be kept, got %v", queries[0].samples)
+	}
+	if len(queries[1].samples) != 0 || !queries[1].updated.IsZero() {
+		t.Errorf("Expected no samples for the changed target, got %v", queries[1].samples)
+	}
+
+	// Changed static labels change all samples.
+	c.Labels = map[string]string{"env": "production"}
+	queries = newBackgroundQueries(c)
+	carrySamples(bq.queries, queries)
+	if len(queries[0].samples) != 0 {
+		t.Errorf("Expected no samples after changing static labels, got %v", queries[0].samples)
+	}
+}
+
+func TestTargetQueriesSpread(t *testing.T) {
+	c := &config.Config{}
+	for i := 0; i < 4; i++ {
+		c.Targets = append(c.Targets, webAppTarget("BytesReceived"))
+	}
+	c.Targets[3].Resource = azuretest.VMResource
+	c.Targets[3].Interval = model.Duration(2 * time.Minute)
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := newTargetQueries(c)
+	for i, want := range []struct {
+		interval, offset time.Duration
+	}{
+		{time.Minute, 0},
+		{time.Minute, 15 * time.Second},
+		{time.Minute, 30 * time.Second},
+		{2 * time.Minute, 90 * time.Second},
+	} {
+		if queries[i].interval != want.interval || queries[i].offset != want.offset {
+			t.Errorf("Query %d: expected interval %v and offset %v, got %v and %v", i, want.interval, want.offset, queries[i].interval, queries[i].offset)
+		}
+	}
+	if queries[3].resource != azuretest.VMResource {
+		t.Errorf("Unexpected resource %q", queries[3].resource)
+	}
+}
+
+func TestCheckCollectionMode(t *testing.T) {
+	for _, tc := range []struct {
+		mode        string
+		remoteWrite bool
+		backfill    bool
+		err         bool
+	}{
+		{mode: collectionModeAsync, remoteWrite: true, backfill: true},
+		{mode: collectionModeAsync, backfill: true, err: true},
+		{mode: collectionModeSync, remoteWrite: true, backfill: true, err: true},
+		{mode: collectionModeSync, remoteWrite: true, err: true},
+		{mode: collectionModeSync},
+	} {
+		reset := setCollectionMode(tc.mode)
+		c := &config.Config{Backfill: config.BackfillConfig{Enabled: tc.backfill}}
+		if tc.remoteWrite {
+			c.RemoteWrite = []*config.RemoteWriteConfig{{URL: "http://prometheus/api/v1/write"}}
+		}
+		if err := checkCollectionMode(c); (err != nil) != tc.err {
+			t.Errorf("%s mode, remote write %v, backfill %v: unexpected error %v", tc.mode, tc.remoteWrite, tc.backfill, err)
+		}
+		reset()
+	}
+}
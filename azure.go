@@ -4,14 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/go-kit/kit/log/level"
 )
 
 // AzureMetricDefinitionResponse represents metric definition response for a given resource from Azure.
@@ -20,121 +22,443 @@ type AzureMetricDefinitionResponse struct {
 }
 type metricDefinitionResponse struct {
 	Dimensions []struct {
-		LocalizedValue string `json:"localizedValue"`
-		Value          string `json:"value"`
-	} `json:"dimensions"`
-	ID                   string `json:"id"`
-	IsDimensionRequired  bool   `json:"isDimensionRequired"`
+		LocalizedValue string `json:"localizedValue" yaml:"localizedValue"`
+		Value          string `json:"value" yaml:"value"`
+	} `json:"dimensions" yaml:"dimensions"`
+	ID                   string `json:"id" yaml:"id"`
+	IsDimensionRequired  bool   `json:"isDimensionRequired" yaml:"isDimensionRequired"`
 	MetricAvailabilities []struct {
-		Retention string `json:"retention"`
-		TimeGrain string `json:"timeGrain"`
-	} `json:"metricAvailabilities"`
+		Retention string `json:"retention" yaml:"retention"`
+		TimeGrain string `json:"timeGrain" yaml:"timeGrain"`
+	} `json:"metricAvailabilities" yaml:"metricAvailabilities"`
 	Name struct {
-		LocalizedValue string `json:"localizedValue"`
-		Value          string `json:"value"`
+		LocalizedValue string `json:"localizedValue" yaml:"localizedValue"`
+		Value          string `json:"value" yaml:"value"`
+	} `json:"name" yaml:"name"`
+	PrimaryAggregationType    string   `json:"primaryAggregationType" yaml:"primaryAggregationType"`
+	SupportedAggregationTypes []string `json:"supportedAggregationTypes" yaml:"supportedAggregationTypes"`
+	ResourceID                string   `json:"resourceId" yaml:"resourceId"`
+	Unit                      string   `json:"unit" yaml:"unit"`
+}
+
+// AzureMetricNamespaceResponse represents the metric namespaces available for a given resource from Azure.
+type AzureMetricNamespaceResponse struct {
+	MetricNamespaces []struct {
+		ID             string `json:"id" yaml:"id"`
+		Name           string `json:"name" yaml:"name"`
+		Classification string `json:"classification" yaml:"classification"`
+		Properties     struct {
+			MetricNamespaceName string `json:"metricNamespaceName" yaml:"metricNamespaceName"`
+		} `json:"properties" yaml:"properties"`
+	} `json:"value"`
+}
+
+// AzureMetricDataPoint represents a single data point of a metric timeseries. Aggregations Azure
+// has no value for are nil.
+type AzureMetricDataPoint struct {
+	TimeStamp string   `json:"timeStamp"`
+	Total     *float64 `json:"total"`
+	Average   *float64 `json:"average"`
+	Minimum   *float64 `json:"minimum"`
+	Maximum   *float64 `json:"maximum"`
+}
+
+// aggregation returns the value of the given aggregation, nil if absent.
+func (p AzureMetricDataPoint) aggregation(name string) *float64 {
+	switch name {
+	case "Total":
+		return p.Total
+	case "Average":
+		return p.Average
+	case "Minimum":
+		return p.Minimum
+	case "Maximum":
+		return p.Maximum
+	}
+	return nil
+}
+
+// latestValue returns the value of the given aggregation in the most recent data point having one.
+func latestValue(data []AzureMetricDataPoint, aggregation string) (float64, bool) {
+	for i := len(data) - 1; i >= 0; i-- {
+		if v := data[i].aggregation(aggregation); v != nil {
+			return *v, true
+		}
+	}
+	return 0, false
+}
+
+// AzureMetricTimeseries is a timeseries of a metric value response, identified by its
+// dimension values if the metric is split by dimensions.
+type AzureMetricTimeseries struct {
+	MetadataValues []AzureMetricMetadataValue `json:"metadatavalues"`
+	Data           []AzureMetricDataPoint     `json:"data"`
+}
+
+// AzureMetricMetadataValue is the value of a dimension of a timeseries.
+type AzureMetricMetadataValue struct {
+	Name struct {
+		Value string `json:"value"`
 	} `json:"name"`
-	PrimaryAggregationType string `json:"primaryAggregationType"`
-	ResourceID             string `json:"resourceId"`
-	Unit                   string `json:"unit"`
+	Value string `json:"value"`
+}
+
+// dimension returns the value of the given dimension, empty if the timeseries doesn't have it.
+// Azure may return dimension names in a different case than queried.
+func (ts AzureMetricTimeseries) dimension(name string) string {
+	for _, v := range ts.MetadataValues {
+		if strings.EqualFold(v.Name.Value, name) {
+			return v.Value
+		}
+	}
+	return ""
+}
+
+// otherDimensionValue is the dimension value of the series aggregating the timeseries beyond
+// the series limit of a metric.
+const otherDimensionValue = "other"
+
+// limitTimeseries returns the first max timeseries, and if there are more, a timeseries
+// aggregating the remaining ones with all dimensions set to otherDimensionValue. All
+// timeseries are returned if max is 0.
+func limitTimeseries(timeseries []AzureMetricTimeseries, dimensions []string, max int) []AzureMetricTimeseries {
+	if max == 0 || len(timeseries) <= max {
+		return timeseries
+	}
+	other := AzureMetricTimeseries{Data: mergeDataPoints(timeseries[max:])}
+	for _, d := range dimensions {
+		v := AzureMetricMetadataValue{Value: otherDimensionValue}
+		v.Name.Value = d
+		other.MetadataValues = append(other.MetadataValues, v)
+	}
+	return append(timeseries[:max:max], other)
+}
+
+// mergeDataPoints aggregates the data points of the timeseries with the same timestamp. Totals
+// are summed up, and the minimum and maximum taken. Averages are left out, as the sample counts
+// needed to weight them aren't queried.
+func mergeDataPoints(timeseries []AzureMetricTimeseries) []AzureMetricDataPoint {
+	var merged []AzureMetricDataPoint
+	index := map[string]int{}
+	for _, ts := range timeseries {
+		for _, p := range ts.Data {
+			i, ok := index[p.TimeStamp]
+			if !ok {
+				i = len(merged)
+				index[p.TimeStamp] = i
+				merged = append(merged, AzureMetricDataPoint{TimeStamp: p.TimeStamp})
+			}
+			m := &merged[i]
+			m.Total = mergeValue(m.Total, p.Total, func(a, b float64) float64 { return a + b })
+			m.Minimum = mergeValue(m.Minimum, p.Minimum, math.Min)
+			m.Maximum = mergeValue(m.Maximum, p.Maximum, math.Max)
+		}
+	}
+	return merged
+}
+
+// mergeValue returns the merge of a and b, the one that is set if only one is.
+func mergeValue(a, b *float64, merge func(a, b float64) float64) *float64 {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	v := merge(*a, *b)
+	return &v
 }
 
 // AzureMetricValueResponse represents a metric value response for a given metric definition.
 type AzureMetricValueResponse struct {
 	Value []struct {
-		Timeseries []struct {
-			Data []struct {
-				TimeStamp string  `json:"timeStamp"`
-				Total     float64 `json:"total"`
-				Average   float64 `json:"average"`
-				Minimum   float64 `json:"minimum"`
-				Maximum   float64 `json:"maximum"`
-			} `json:"data"`
-		} `json:"timeseries"`
-		ID   string `json:"id"`
-		Name struct {
+		Timeseries []AzureMetricTimeseries `json:"timeseries"`
+		ID         string                  `json:"id"`
+		Name       struct {
 			LocalizedValue string `json:"localizedValue"`
 			Value          string `json:"value"`
 		} `json:"name"`
 		Type string `json:"type"`
 		Unit string `json:"unit"`
 	} `json:"value"`
-	APIError struct {
+	ResourceRegion string `json:"resourceregion"`
+	APIError       struct {
 		Code    string `json:"code"`
 		Message string `json:"message"`
 	} `json:"error"`
 }
 
+// azureError is an error response of an Azure API.
+type azureError struct {
+	status    int
+	code      string
+	message   string
+	requestID string
+}
+
+func (e *azureError) Error() string {
+	if e.code == "" {
+		return fmt.Sprintf("Azure API returned status code %d", e.status)
+	}
+	return fmt.Sprintf("Azure API returned status code %d: %s - %s", e.status, e.code, e.message)
+}
+
+// isInvalidMetricError returns whether Azure rejected a metrics request for naming a metric the
+// resource doesn't have. Other bad requests, e.g. for an invalid filter, fail for every metric.
+func isInvalidMetricError(err error) bool {
+	e, ok := err.(*azureError)
+	if !ok || e.status != http.StatusBadRequest || e.code != "BadRequest" {
+		return false
+	}
+	return strings.Contains(e.message, "Failed to find metric configuration") || strings.Contains(e.message, "Valid metrics:")
+}
+
+// newAzureError returns the error described by an Azure API error response.
+func newAzureError(resp *http.Response, body []byte) *azureError {
+	e := &azureError{
+		status:    resp.StatusCode,
+		requestID: resp.Header.Get("x-ms-request-id"),
+	}
+	var data struct {
+		APIError struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &data) == nil {
+		e.code = data.APIError.Code
+		e.message = data.APIError.Message
+	}
+	return e
+}
+
 // AzureClient represents our client to talk to the Azure api
 type AzureClient struct {
-	client               *http.Client
-	accessToken          string
-	accessTokenExpiresOn time.Time
+	// clientMu guards client, which is replaced on reloads while requests are made.
+	clientMu sync.RWMutex
+	client   *http.Client
+
+	mu sync.Mutex
+	// Access tokens by credentials profile and resource, the top level credentials use the empty name.
+	accessTokens map[tokenKey]accessToken
+}
+
+type tokenKey struct {
+	profile  string
+	resource string
+}
+
+type accessToken struct {
+	token     string
+	expiresOn time.Time
 }
 
 // NewAzureClient returns an Azure client to talk the Azure API
 func NewAzureClient() *AzureClient {
 	return &AzureClient{
-		client:               &http.Client{},
-		accessToken:          "",
-		accessTokenExpiresOn: time.Time{},
+		client:       &http.Client{},
+		accessTokens: make(map[tokenKey]accessToken),
 	}
 }
 
-func (ac *AzureClient) getAccessToken() error {
-	target := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/token", sc.C.Credentials.TenantID)
+// setHTTPClient replaces the HTTP client according to the given configuration.
+func (ac *AzureClient) setHTTPClient(cfg config.HTTPClientConfig) error {
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return fmt.Errorf("Error creating HTTP client: %v", err)
+	}
+	ac.clientMu.Lock()
+	ac.client = client
+	ac.clientMu.Unlock()
+	return nil
+}
+
+// httpClient returns the current HTTP client.
+func (ac *AzureClient) httpClient() *http.Client {
+	ac.clientMu.RLock()
+	defer ac.clientMu.RUnlock()
+	return ac.client
+}
+
+// getAccessToken returns the Azure Resource Manager access token for the credentials profile
+// of the target.
+func (ac *AzureClient) getAccessToken(c *config.Config, target config.Target) (string, error) {
+	return ac.getResourceAccessToken(c, target.Credentials, c.Endpoints.ResourceManager)
+}
+
+// getResourceAccessToken returns the access token for the given credentials profile and
+// resource, requesting a new one if the cached token expires soon.
+func (ac *AzureClient) getResourceAccessToken(c *config.Config, profile, resource string) (string, error) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	key := tokenKey{profile: profile, resource: resource}
+	t, ok := ac.accessTokens[key]
+	if ok && time.Now().UTC().Before(t.expiresOn.Add(-10*time.Minute)) {
+		return t.token, nil
+	}
+	token, expiresOn, err := ac.requestAccessToken(c.Endpoints, c.ProfileCredentials(profile), resource)
+	if err != nil {
+		return "", err
+	}
+	ac.accessTokens[key] = accessToken{token: token, expiresOn: expiresOn}
+	return token, nil
+}
+
+// refreshAccessTokens drops the cached access tokens and requests new ones for the
+// credentials profiles used by the configured targets.
+func (ac *AzureClient) refreshAccessTokens(c *config.Config) error {
+	ac.mu.Lock()
+	ac.accessTokens = make(map[tokenKey]accessToken)
+	ac.mu.Unlock()
+
+	for _, target := range c.Targets {
+		if _, err := ac.getAccessToken(c, target); err != nil {
+			if target.Credentials != "" {
+				return fmt.Errorf("credentials profile %s: %v", target.Credentials, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// requestAccessToken requests a new access token for the given credentials and resource.
+func (ac *AzureClient) requestAccessToken(endpoints config.CloudEndpoints, credentials config.Credentials, resource string) (string, time.Time, error) {
+	secret, err := credentials.GetClientSecret()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	target := fmt.Sprintf("%s/%s/oauth2/token", endpoints.ActiveDirectory, credentials.TenantID)
 	form := url.Values{
 		"grant_type":    {"client_credentials"},
-		"resource":      {"https://management.azure.com/"},
-		"client_id":     {sc.C.Credentials.ClientID},
-		"client_secret": {sc.C.Credentials.ClientSecret},
+		"resource":      {resource + "/"},
+		"client_id":     {credentials.ClientID},
+		"client_secret": {secret},
 	}
-	resp, err := ac.client.PostForm(target, form)
+	resp, err := ac.httpClient().PostForm(target, form)
 	if err != nil {
-		return fmt.Errorf("Error authenticating against Azure API: %v", err)
+		return "", time.Time{}, fmt.Errorf("Error authenticating against Azure API: %v", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("Did not get status code 200, got: %d", resp.StatusCode)
+		return "", time.Time{}, fmt.Errorf("Did not get status code 200, got: %d", resp.StatusCode)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("Error reading body of response: %v", err)
+		return "", time.Time{}, fmt.Errorf("Error reading body of response: %v", err)
 	}
 	var data map[string]interface{}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return fmt.Errorf("Error unmarshalling response body: %v", err)
+		return "", time.Time{}, fmt.Errorf("Error unmarshalling response body: %v", err)
 	}
-	ac.accessToken = data["access_token"].(string)
+	token := data["access_token"].(string)
 	expiresOn, err := strconv.ParseInt(data["expires_on"].(string), 10, 64)
 	if err != nil {
-		return fmt.Errorf("Error ParseInt of expires_on failed: %v", err)
+		return "", time.Time{}, fmt.Errorf("Error ParseInt of expires_on failed: %v", err)
 	}
-	ac.accessTokenExpiresOn = time.Unix(expiresOn, 0).UTC()
 
-	return nil
+	return token, time.Unix(expiresOn, 0).UTC(), nil
 }
 
 // Loop through all specified resource targets and get their respective metric definitions.
-func (ac *AzureClient) getMetricDefinitions() (map[string]AzureMetricDefinitionResponse, error) {
+func (ac *AzureClient) getMetricDefinitions(c *config.Config) ([]resourceDefinitions, error) {
+	definitions := []resourceDefinitions{}
+	seen := make(map[[2]string]bool)
+
+	for _, target := range c.Targets {
+		key := [2]string{target.Resource, target.MetricNamespace}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		token, err := ac.getAccessToken(c, target)
+		if err != nil {
+			return nil, err
+		}
+		def, err := ac.getTargetMetricDefinitions(c, target, target.MetricNamespace, token)
+		if err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, resourceDefinitions{
+			Resource:  target.Resource,
+			Namespace: target.MetricNamespace,
+			Metrics:   def.MetricDefinitionResponses,
+			target:    target,
+		})
+	}
+	return definitions, nil
+}
+
+// getTargetMetricDefinitions gets the metric definitions of a single target resource in the given
+// metric namespace. The default namespace of the resource is used if it is empty.
+func (ac *AzureClient) getTargetMetricDefinitions(c *config.Config, target config.Target, namespace string, accessToken string) (AzureMetricDefinitionResponse, error) {
 	apiVersion := "2018-01-01"
-	definitions := make(map[string]AzureMetricDefinitionResponse)
+	metricsResource := fmt.Sprintf("subscriptions/%s%s", c.TargetCredentials(target).SubscriptionID, target.Resource)
+	metricsTarget := fmt.Sprintf("%s/%s/providers/microsoft.insights/metricDefinitions", c.Endpoints.Monitor, metricsResource)
+	req, err := http.NewRequest("GET", metricsTarget, nil)
+	if err != nil {
+		return AzureMetricDefinitionResponse{}, fmt.Errorf("Error creating HTTP request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	for _, target := range sc.C.Targets {
-		metricsResource := fmt.Sprintf("subscriptions/%s%s", sc.C.Credentials.SubscriptionID, target.Resource)
-		metricsTarget := fmt.Sprintf("https://management.azure.com/%s/providers/microsoft.insights/metricDefinitions?api-version=%s", metricsResource, apiVersion)
-		req, err := http.NewRequest("GET", metricsTarget, nil)
+	values := url.Values{}
+	if namespace != "" {
+		values.Add("metricnamespace", namespace)
+	}
+	values.Add("api-version", apiVersion)
+	req.URL.RawQuery = values.Encode()
+
+	resp, err := ac.httpClient().Do(req)
+	if err != nil {
+		return AzureMetricDefinitionResponse{}, fmt.Errorf("Error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return AzureMetricDefinitionResponse{}, fmt.Errorf("Error reading body of response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return AzureMetricDefinitionResponse{}, newAzureError(resp, body)
+	}
+
+	def := AzureMetricDefinitionResponse{}
+	err = json.Unmarshal(body, &def)
+	if err != nil {
+		return AzureMetricDefinitionResponse{}, fmt.Errorf("Error unmarshalling response body: %v", err)
+	}
+	return def, nil
+}
+
+// Loop through all specified resource targets and get their available metric namespaces.
+func (ac *AzureClient) getMetricNamespaces(c *config.Config) (map[string]AzureMetricNamespaceResponse, error) {
+	apiVersion := "2017-12-01-preview"
+	namespaces := make(map[string]AzureMetricNamespaceResponse)
+
+	for _, target := range c.Targets {
+		if _, ok := namespaces[target.Resource]; ok {
+			continue
+		}
+		token, err := ac.getAccessToken(c, target)
+		if err != nil {
+			return nil, err
+		}
+		metricsResource := fmt.Sprintf("subscriptions/%s%s", c.TargetCredentials(target).SubscriptionID, target.Resource)
+		namespacesTarget := fmt.Sprintf("%s/%s/providers/microsoft.insights/metricNamespaces?api-version=%s", c.Endpoints.Monitor, metricsResource, apiVersion)
+		req, err := http.NewRequest("GET", namespacesTarget, nil)
 		if err != nil {
 			return nil, fmt.Errorf("Error creating HTTP request: %v", err)
 		}
-		req.Header.Set("Authorization", "Bearer "+ac.accessToken)
-		resp, err := ac.client.Do(req)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := ac.httpClient().Do(req)
 		if err != nil {
 			return nil, fmt.Errorf("Error: %v", err)
 		}
-		defer resp.Body.Close()
 		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
 		if err != nil {
 			return nil, fmt.Errorf("Error reading body of response: %v", err)
 		}
@@ -142,41 +466,56 @@ func (ac *AzureClient) getMetricDefinitions() (map[string]AzureMetricDefinitionR
 			return nil, fmt.Errorf("Error: %v", string(body))
 		}
 
-		def := AzureMetricDefinitionResponse{}
-		err = json.Unmarshal(body, &def)
+		ns := AzureMetricNamespaceResponse{}
+		err = json.Unmarshal(body, &ns)
 		if err != nil {
 			return nil, fmt.Errorf("Error unmarshalling response body: %v", err)
 		}
-		definitions[target.Resource] = def
+		namespaces[target.Resource] = ns
 	}
-	return definitions, nil
+	return namespaces, nil
 }
 
-func (ac *AzureClient) getMetricValue(metricNames string, target config.Target) (AzureMetricValueResponse, error) {
+// getMetricValue queries the given metrics of the target between startTime and endTime. A
+// single metric having dimensions is split by them.
+func (ac *AzureClient) getMetricValue(c *config.Config, metrics []config.Metric, namespace string, target config.Target, startTime, endTime string) (AzureMetricValueResponse, error) {
 	apiVersion := "2018-01-01"
-	now := time.Now().UTC()
-	refreshAt := ac.accessTokenExpiresOn.Add(-10 * time.Minute)
-	if now.After(refreshAt) {
-		err := ac.getAccessToken()
-		if err != nil {
-			return AzureMetricValueResponse{}, fmt.Errorf("Error refreshing access token: %v", err)
-		}
+	token, err := ac.getAccessToken(c, target)
+	if err != nil {
+		return AzureMetricValueResponse{}, fmt.Errorf("Error refreshing access token: %v", err)
 	}
 
-	metricsResource := fmt.Sprintf("subscriptions/%s%s", sc.C.Credentials.SubscriptionID, target.Resource)
-	endTime, startTime := GetTimes()
+	metricsResource := fmt.Sprintf("subscriptions/%s%s", c.TargetCredentials(target).SubscriptionID, target.Resource)
 
-	metricValueEndpoint := fmt.Sprintf("https://management.azure.com/%s/providers/microsoft.insights/metrics", metricsResource)
+	metricValueEndpoint := fmt.Sprintf("%s/%s/providers/microsoft.insights/metrics", c.Endpoints.Monitor, metricsResource)
 
 	req, err := http.NewRequest("GET", metricValueEndpoint, nil)
 	if err != nil {
 		return AzureMetricValueResponse{}, fmt.Errorf("Error creating HTTP request: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+ac.accessToken)
+	req.Header.Set("Authorization", "Bearer "+token)
 
+	names := metricNames(metrics)
 	values := url.Values{}
-	if metricNames != "" {
-		values.Add("metricnames", metricNames)
+	if names != "" {
+		values.Add("metricnames", names)
+	}
+	if namespace != "" {
+		values.Add("metricnamespace", namespace)
+	}
+	if len(metrics) == 1 && len(metrics[0].Dimensions) > 0 {
+		m := metrics[0]
+		filters := make([]string, len(m.Dimensions))
+		for i, d := range m.Dimensions {
+			filters[i] = fmt.Sprintf("%s eq '*'", d)
+		}
+		values.Add("$filter", strings.Join(filters, " and "))
+		if m.Top > 0 {
+			values.Add("top", strconv.Itoa(m.Top))
+		}
+		if m.OrderBy != "" {
+			values.Add("orderby", m.OrderBy)
+		}
 	}
 	if len(target.Aggregations) > 0 {
 		values.Add("aggregation", strings.Join(target.Aggregations, ","))
@@ -188,20 +527,20 @@ func (ac *AzureClient) getMetricValue(metricNames string, target config.Target)
 
 	req.URL.RawQuery = values.Encode()
 
-	log.Printf("GET %s", req.URL)
-	resp, err := ac.client.Do(req)
+	resp, err := ac.httpClient().Do(req)
 	if err != nil {
 		return AzureMetricValueResponse{}, fmt.Errorf("Error: %v", err)
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return AzureMetricValueResponse{}, fmt.Errorf("Unable to query metrics API with status code: %d", resp.StatusCode)
-	}
+	level.Debug(logger).Log("msg", "Queried metrics", "target", target.Resource, "metrics", names, "url", req.URL, "status", resp.StatusCode, "request_id", resp.Header.Get("x-ms-request-id"))
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return AzureMetricValueResponse{}, fmt.Errorf("Error reading body of response: %v", err)
 	}
+	if resp.StatusCode != http.StatusOK {
+		return AzureMetricValueResponse{}, newAzureError(resp, body)
+	}
 
 	var data AzureMetricValueResponse
 	err = json.Unmarshal(body, &data)
@@ -0,0 +1,97 @@
+package azuretest
+
+import "time"
+
+// Resource paths of the fixtures.
+const (
+	WebAppResource = "/resourceGroups/blog-group/providers/Microsoft.Web/sites/blog"
+	VMResource     = "/resourceGroups/vm-group/providers/Microsoft.Compute/virtualMachines/vm1"
+)
+
+// F returns a pointer to the given value, for use in data points.
+func F(v float64) *float64 {
+	return &v
+}
+
+// Point returns a data point with all aggregations set to the given values.
+func Point(ts time.Time, total, average, minimum, maximum float64) DataPoint {
+	return DataPoint{TimeStamp: ts, Total: F(total), Average: F(average), Minimum: F(minimum), Maximum: F(maximum), Count: F(1)}
+}
+
+// FixtureTime is the time of the last data point in the fixtures.
+var FixtureTime = time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// WebApp returns a web app resource with a metric having a single timeseries
+// and a metric having one timeseries per instance.
+func WebApp() Resource {
+	return Resource{
+		ID:   WebAppResource,
+		Type: "Microsoft.Web/sites",
+		Tags: map[string]string{"env": "test"},
+		Metrics: []Metric{
+			{
+				Name:               "BytesReceived",
+				Unit:               "Bytes",
+				PrimaryAggregation: "Total",
+				Dimensions:         []string{"Instance"},
+				Timeseries: []Timeseries{{
+					Data: []DataPoint{
+						Point(FixtureTime.Add(-time.Minute), 1000, 500, 100, 900),
+						Point(FixtureTime, 2048, 1024, 512, 1536),
+					},
+				}},
+			},
+			{
+				Name:               "Http5xx",
+				Unit:               "Count",
+				PrimaryAggregation: "Total",
+				Dimensions:         []string{"Instance"},
+				Timeseries: []Timeseries{
+					{
+						Metadata: map[string]string{"instance": "instance-1"},
+						Data:     []DataPoint{Point(FixtureTime, 3, 1.5, 1, 2)},
+					},
+					{
+						Metadata: map[string]string{"instance": "instance-2"},
+						Data:     []DataPoint{Point(FixtureTime, 5, 2.5, 2, 3)},
+					},
+				},
+			},
+			{
+				Name:                "Requests",
+				Unit:                "Count",
+				PrimaryAggregation:  "Total",
+				Dimensions:          []string{"Url"},
+				IsDimensionRequired: true,
+			},
+		},
+	}
+}
+
+// VM returns a virtual machine resource with a platform metric and a guest OS metric
+// in a custom metric namespace.
+func VM() Resource {
+	return Resource{
+		ID:   VMResource,
+		Type: "Microsoft.Compute/virtualMachines",
+		Metrics: []Metric{
+			{
+				Name:               "Percentage CPU",
+				Unit:               "Percent",
+				PrimaryAggregation: "Average",
+				Timeseries: []Timeseries{{
+					Data: []DataPoint{Point(FixtureTime, 42, 21, 3, 40)},
+				}},
+			},
+			{
+				Name:               "Memory\\Available Bytes",
+				Namespace:          "azure.vm.windows.guestmetrics",
+				Unit:               "Bytes",
+				PrimaryAggregation: "Average",
+				Timeseries: []Timeseries{{
+					Data: []DataPoint{Point(FixtureTime, 4096, 2048, 1024, 3072)},
+				}},
+			},
+		},
+	}
+}
@@ -0,0 +1,885 @@
+// Package azuretest provides a fake Azure server serving the Azure Active Directory token,
+// Azure Resource Manager, Azure Monitor, Log Analytics, Resource Graph, Resource Health and
+// Cost Management endpoints used by the exporter, for use in tests.
+package azuretest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+)
+
+// Credentials accepted by the fake server.
+const (
+	TenantID       = "00000000-0000-0000-0000-000000000001"
+	SubscriptionID = "00000000-0000-0000-0000-000000000002"
+	ClientID       = "00000000-0000-0000-0000-000000000003"
+	ClientSecret   = "fake-secret"
+	AccessToken    = "fake-access-token"
+)
+
+// MaxMetricsPerRequest is the number of metrics Azure Monitor accepts in a single request.
+const MaxMetricsPerRequest = 20
+
+// Server is a fake Azure server.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	clients    map[string]client
+	resources  map[string]*Resource
+	workspaces map[string]Table
+	graphRows  []map[string]interface{}
+	graphPage  int
+	graphToken string
+	costs      map[string]Table
+	costPage   int
+	failures   []failure
+	requests   []Request
+}
+
+// Resource is a fake Azure resource and its metrics.
+type Resource struct {
+	// ID is the resource path relative to the subscription, e.g. /resourceGroups/rg/providers/Microsoft.Web/sites/app
+	ID       string
+	Type     string
+	Location string
+	Tags     map[string]string
+	Metrics  []Metric
+	// Health is the Resource Health availability status, Available if unset.
+	Health Health
+}
+
+// Health is the Resource Health availability status of a fake resource.
+type Health struct {
+	AvailabilityState string
+	Summary           string
+	ReasonType        string
+	ReasonChronicity  string
+}
+
+// Metric is a fake Azure Monitor metric.
+type Metric struct {
+	Name string
+	// Namespace is the metric namespace, empty for the default namespace of the resource.
+	Namespace           string
+	Unit                string
+	PrimaryAggregation  string
+	Dimensions          []string
+	IsDimensionRequired bool
+	Timeseries          []Timeseries
+}
+
+// Timeseries is a timeseries of a fake metric, identified by its dimension values.
+type Timeseries struct {
+	Metadata map[string]string
+	Data     []DataPoint
+}
+
+// DataPoint is a single data point of a timeseries. Nil aggregations are absent from responses.
+type DataPoint struct {
+	TimeStamp time.Time
+	Total     *float64
+	Average   *float64
+	Minimum   *float64
+	Maximum   *float64
+	Count     *float64
+}
+
+// Table is the result of a fake Log Analytics query.
+type Table struct {
+	Columns []Column
+	Rows    [][]interface{}
+}
+
+// Column is a column of a Log Analytics query result.
+type Column struct {
+	Name string
+	Type string
+}
+
+// Request is a request received by the fake server.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Body   string
+	// Token is the bearer token the request was authorized with.
+	Token string
+}
+
+// client is a service principal known to the fake server.
+type client struct {
+	tenantID string
+	secret   string
+	token    string
+}
+
+type failure struct {
+	status     int
+	code       string
+	message    string
+	retryAfter time.Duration
+}
+
+// NewServer starts a fake Azure server serving the given resources.
+// It must be closed after use.
+func NewServer(resources ...Resource) *Server {
+	s := &Server{
+		clients:    map[string]client{ClientID: {tenantID: TenantID, secret: ClientSecret, token: AccessToken}},
+		resources:  make(map[string]*Resource),
+		workspaces: make(map[string]Table),
+		costs:      make(map[string]Table),
+	}
+	for _, r := range resources {
+		s.AddResource(r)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// AddResource adds or replaces a resource.
+func (s *Server) AddResource(r Resource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Type == "" {
+		r.Type = resourceType(r.ID)
+	}
+	if r.Location == "" {
+		r.Location = "westeurope"
+	}
+	s.resources[strings.ToLower(r.ID)] = &r
+}
+
+// AddWorkspace adds or replaces a Log Analytics workspace, all queries against it return the given table.
+func (s *Server) AddWorkspace(id string, result Table) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workspaces[id] = result
+}
+
+// SetResourceGraphRows sets the rows returned by Resource Graph queries, in pages of the given size.
+// The page size requested by the client is used if pageSize is 0.
+func (s *Server) SetResourceGraphRows(rows []map[string]interface{}, pageSize int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.graphRows = rows
+	s.graphPage = pageSize
+}
+
+// SetResourceGraphSkipToken makes every page of Resource Graph results point to the page of
+// the given $skipToken as the next one, as if the API kept returning the same token.
+func (s *Server) SetResourceGraphSkipToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.graphToken = token
+}
+
+// SetCosts sets the result of Cost Management queries with the given timeframe, e.g. MonthToDate
+// or Custom, in pages of the given size. All rows are returned in one page if pageSize is 0.
+func (s *Server) SetCosts(timeframe string, result Table, pageSize int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.costs[timeframe] = result
+	s.costPage = pageSize
+}
+
+// AddClient registers a service principal of the given tenant and returns the access token issued to it.
+func (s *Server) AddClient(tenantID, clientID, clientSecret string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := "fake-access-token-" + clientID
+	s.clients[clientID] = client{tenantID: tenantID, secret: clientSecret, token: token}
+	return token
+}
+
+// FailNext makes the next n Azure Resource Manager, Azure Monitor and Log Analytics requests fail
+// with the given status code and Azure error.
+func (s *Server) FailNext(n int, status int, code, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{status: status, code: code, message: message})
+	}
+}
+
+// Throttle makes the next n requests fail like Azure Resource Manager does when throttling.
+func (s *Server) Throttle(n int, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{
+			status:     http.StatusTooManyRequests,
+			code:       "TooManyRequests",
+			message:    "The request is being throttled.",
+			retryAfter: retryAfter,
+		})
+	}
+}
+
+// Requests returns the Azure Resource Manager, Azure Monitor and Log Analytics requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Endpoints returns the endpoints configuration pointing at the fake server.
+func (s *Server) Endpoints() config.CloudEndpoints {
+	return config.CloudEndpoints{
+		ActiveDirectory: s.URL,
+		ResourceManager: s.URL,
+		Monitor:         s.URL,
+		LogAnalytics:    s.URL,
+	}
+}
+
+// Config returns an exporter configuration using the fake server and its credentials.
+func (s *Server) Config(targets ...config.Target) *config.Config {
+	return &config.Config{
+		Cloud:     config.CloudCustom,
+		Endpoints: s.Endpoints(),
+		Credentials: config.Credentials{
+			SubscriptionID: SubscriptionID,
+			ClientID:       ClientID,
+			ClientSecret:   ClientSecret,
+			TenantID:       TenantID,
+		},
+		Targets: targets,
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/oauth2/token") {
+		s.serveToken(w, r, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), "/oauth2/token"))
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), Body: string(body), Token: token})
+	w.Header().Set("x-ms-request-id", fmt.Sprintf("fake-request-%d", len(s.requests)))
+	var f *failure
+	if len(s.failures) > 0 {
+		f = &s.failures[0]
+		s.failures = s.failures[1:]
+	}
+	authorized := false
+	for _, c := range s.clients {
+		if token == c.token {
+			authorized = true
+		}
+	}
+	s.mu.Unlock()
+
+	if !authorized {
+		writeError(w, http.StatusUnauthorized, "InvalidAuthenticationToken", "The access token is invalid.")
+		return
+	}
+	if f != nil {
+		if f.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(f.retryAfter.Seconds())))
+		}
+		writeError(w, f.status, f.code, f.message)
+		return
+	}
+
+	if r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/v1/workspaces/") && strings.HasSuffix(r.URL.Path, "/query") {
+		s.serveLogQuery(w, r, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/workspaces/"), "/query"))
+		return
+	}
+
+	if r.Method == "POST" && strings.EqualFold(r.URL.Path, "/providers/Microsoft.ResourceGraph/resources") {
+		s.serveResourceGraph(w, r)
+		return
+	}
+
+	prefix := "/subscriptions/" + SubscriptionID
+	path := r.URL.Path
+	if !strings.HasPrefix(path, prefix) {
+		writeError(w, http.StatusNotFound, "SubscriptionNotFound", fmt.Sprintf("The subscription of %s could not be found.", path))
+		return
+	}
+	path = strings.TrimPrefix(path, prefix)
+
+	lower := strings.ToLower(path)
+	switch {
+	case r.Method == "GET" && (lower == "/resources" || strings.HasSuffix(lower, "/resources") && strings.HasPrefix(lower, "/resourcegroups/")):
+		s.serveResources(w, r, strings.TrimSuffix(lower, "/resources"))
+	case r.Method == "POST" && lower == "/metrics:getbatch":
+		s.serveBatch(w, r)
+	case r.Method == "GET" && strings.HasSuffix(lower, "/providers/microsoft.insights/metricdefinitions"):
+		s.serveDefinitions(w, r, path[:len(path)-len("/providers/microsoft.insights/metricDefinitions")])
+	case r.Method == "GET" && strings.HasSuffix(lower, "/providers/microsoft.insights/metricnamespaces"):
+		s.serveNamespaces(w, r, path[:len(path)-len("/providers/microsoft.insights/metricNamespaces")])
+	case r.Method == "POST" && strings.HasSuffix(lower, "/providers/microsoft.costmanagement/query"):
+		s.serveCostQuery(w, r)
+	case r.Method == "GET" && strings.HasSuffix(lower, "/providers/microsoft.resourcehealth/availabilitystatuses/current"):
+		s.serveAvailabilityStatus(w, r, path[:len(path)-len("/providers/Microsoft.ResourceHealth/availabilityStatuses/current")])
+	case r.Method == "GET" && strings.HasSuffix(lower, "/providers/microsoft.insights/metrics"):
+		s.serveMetrics(w, r, path[:len(path)-len("/providers/microsoft.insights/metrics")])
+	default:
+		writeError(w, http.StatusNotFound, "NotFound", fmt.Sprintf("No route for %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request, tenantID string) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	c, ok := s.clients[r.PostForm.Get("client_id")]
+	s.mu.Unlock()
+	if r.PostForm.Get("grant_type") != "client_credentials" || !ok ||
+		c.tenantID != tenantID || c.secret != r.PostForm.Get("client_secret") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":             "invalid_client",
+			"error_description": "AADSTS7000215: Invalid client secret is provided.",
+		})
+		return
+	}
+	writeJSON(w, map[string]string{
+		"token_type":   "Bearer",
+		"resource":     r.PostForm.Get("resource"),
+		"access_token": c.token,
+		"expires_on":   strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10),
+	})
+}
+
+func (s *Server) resource(id string) *Resource {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resources[strings.ToLower(id)]
+}
+
+func (s *Server) serveResources(w http.ResponseWriter, r *http.Request, group string) {
+	s.mu.Lock()
+	ids := []string{}
+	for id := range s.resources {
+		if strings.HasPrefix(id, group+"/") {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	values := []map[string]interface{}{}
+	for _, id := range ids {
+		res := s.resources[id]
+		values = append(values, map[string]interface{}{
+			"id":       "/subscriptions/" + SubscriptionID + res.ID,
+			"name":     res.ID[strings.LastIndex(res.ID, "/")+1:],
+			"type":     res.Type,
+			"location": res.Location,
+			"tags":     res.Tags,
+		})
+	}
+	s.mu.Unlock()
+	writeJSON(w, map[string]interface{}{"value": values})
+}
+
+func (s *Server) serveDefinitions(w http.ResponseWriter, r *http.Request, id string) {
+	res := s.resource(id)
+	if res == nil {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("The Resource '%s' was not found.", id))
+		return
+	}
+	namespace := r.URL.Query().Get("metricnamespace")
+	values := []map[string]interface{}{}
+	for _, m := range res.Metrics {
+		if !inNamespace(res, m, namespace) {
+			continue
+		}
+		dimensions := []map[string]string{}
+		for _, d := range m.Dimensions {
+			dimensions = append(dimensions, map[string]string{"value": d, "localizedValue": d})
+		}
+		primary := m.PrimaryAggregation
+		if primary == "" {
+			primary = "Average"
+		}
+		values = append(values, map[string]interface{}{
+			"id":                        "/subscriptions/" + SubscriptionID + res.ID + "/providers/microsoft.insights/metricdefinitions/" + m.Name,
+			"resourceId":                "/subscriptions/" + SubscriptionID + res.ID,
+			"namespace":                 metricNamespace(res, m),
+			"name":                      map[string]string{"value": m.Name, "localizedValue": m.Name},
+			"isDimensionRequired":       m.IsDimensionRequired,
+			"unit":                      m.Unit,
+			"primaryAggregationType":    primary,
+			"supportedAggregationTypes": []string{"None", "Average", "Minimum", "Maximum", "Total", "Count"},
+			"metricAvailabilities": []map[string]string{
+				{"timeGrain": "PT1M", "retention": "P93D"},
+				{"timeGrain": "PT1H", "retention": "P93D"},
+			},
+			"dimensions": dimensions,
+		})
+	}
+	writeJSON(w, map[string]interface{}{"value": values})
+}
+
+func (s *Server) serveNamespaces(w http.ResponseWriter, r *http.Request, id string) {
+	res := s.resource(id)
+	if res == nil {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("The Resource '%s' was not found.", id))
+		return
+	}
+	seen := map[string]bool{}
+	values := []map[string]interface{}{}
+	for _, m := range append([]Metric{{}}, res.Metrics...) {
+		ns := metricNamespace(res, m)
+		if seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		classification := "Platform"
+		if m.Namespace != "" {
+			classification = "Custom"
+		}
+		values = append(values, map[string]interface{}{
+			"id":             "/subscriptions/" + SubscriptionID + res.ID + "/providers/microsoft.insights/metricNamespaces/" + ns,
+			"name":           ns,
+			"type":           "Microsoft.Insights/metricNamespaces",
+			"classification": classification,
+			"properties":     map[string]string{"metricNamespaceName": ns},
+		})
+	}
+	writeJSON(w, map[string]interface{}{"value": values})
+}
+
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request, id string) {
+	res := s.resource(id)
+	if res == nil {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("The Resource '%s' was not found.", id))
+		return
+	}
+	value, status, err := metricsResponse(res, r.URL.Query())
+	if err != nil {
+		writeError(w, status, "BadRequest", err.Error())
+		return
+	}
+	writeJSON(w, value)
+}
+
+func (s *Server) serveCostQuery(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Type      string `json:"type"`
+		Timeframe string `json:"timeframe"`
+		Dataset   struct {
+			Granularity string `json:"granularity"`
+		} `json:"dataset"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Type == "" || body.Timeframe == "" {
+		writeError(w, http.StatusBadRequest, "BadRequest", "Invalid query definition")
+		return
+	}
+	s.mu.Lock()
+	table, pageSize := s.costs[body.Timeframe], s.costPage
+	s.mu.Unlock()
+
+	start := 0
+	if token := r.URL.Query().Get("$skiptoken"); token != "" {
+		var err error
+		if start, err = strconv.Atoi(token); err != nil {
+			writeError(w, http.StatusBadRequest, "BadRequest", "Invalid $skiptoken")
+			return
+		}
+	}
+	end := len(table.Rows)
+	if pageSize > 0 && start+pageSize < end {
+		end = start + pageSize
+	}
+	if start > end {
+		start = end
+	}
+	rows := table.Rows[start:end]
+	if rows == nil {
+		rows = [][]interface{}{}
+	}
+	columns := []map[string]string{}
+	for _, c := range table.Columns {
+		columns = append(columns, map[string]string{"name": c.Name, "type": c.Type})
+	}
+	properties := map[string]interface{}{"columns": columns, "rows": rows, "nextLink": nil}
+	if end < len(table.Rows) {
+		next := url.Values{"api-version": {r.URL.Query().Get("api-version")}, "$skiptoken": {strconv.Itoa(end)}}
+		properties["nextLink"] = s.URL + r.URL.Path + "?" + next.Encode()
+	}
+	writeJSON(w, map[string]interface{}{
+		"id":         r.URL.Path,
+		"name":       "fake-query",
+		"type":       "Microsoft.CostManagement/query",
+		"properties": properties,
+	})
+}
+
+func (s *Server) serveAvailabilityStatus(w http.ResponseWriter, r *http.Request, id string) {
+	res := s.resource(id)
+	if res == nil {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("The Resource '%s' was not found.", id))
+		return
+	}
+	h := res.Health
+	if h.AvailabilityState == "" {
+		h.AvailabilityState = "Available"
+		h.Summary = "There aren't any known Azure platform problems affecting this resource."
+	}
+	properties := map[string]string{
+		"availabilityState": h.AvailabilityState,
+		"summary":           h.Summary,
+	}
+	if h.ReasonType != "" {
+		properties["reasonType"] = h.ReasonType
+	}
+	if h.ReasonChronicity != "" {
+		properties["reasonChronicity"] = h.ReasonChronicity
+	}
+	writeJSON(w, map[string]interface{}{
+		"id":         "/subscriptions/" + SubscriptionID + res.ID + "/providers/Microsoft.ResourceHealth/availabilityStatuses/current",
+		"name":       "current",
+		"type":       "Microsoft.ResourceHealth/AvailabilityStatuses",
+		"location":   res.Location,
+		"properties": properties,
+	})
+}
+
+func (s *Server) serveBatch(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ResourceIDs []string `json:"resourceids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+	values := []interface{}{}
+	for _, id := range body.ResourceIDs {
+		res := s.resource(strings.TrimPrefix(strings.ToLower(id), strings.ToLower("/subscriptions/"+SubscriptionID)))
+		if res == nil {
+			writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("The Resource '%s' was not found.", id))
+			return
+		}
+		value, status, err := metricsResponse(res, r.URL.Query())
+		if err != nil {
+			writeError(w, status, "BadRequest", err.Error())
+			return
+		}
+		value["resourceid"] = "/subscriptions/" + SubscriptionID + res.ID
+		values = append(values, value)
+	}
+	writeJSON(w, map[string]interface{}{"values": values})
+}
+
+func (s *Server) serveLogQuery(w http.ResponseWriter, r *http.Request, workspace string) {
+	var body struct {
+		Query    string `json:"query"`
+		Timespan string `json:"timespan"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Query == "" {
+		writeError(w, http.StatusBadRequest, "BadArgumentError", "The request had some invalid properties")
+		return
+	}
+	s.mu.Lock()
+	table, ok := s.workspaces[workspace]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "WorkspaceNotFoundError", fmt.Sprintf("Workspace %s not found.", workspace))
+		return
+	}
+
+	columns := []map[string]string{}
+	for _, c := range table.Columns {
+		columns = append(columns, map[string]string{"name": c.Name, "type": c.Type})
+	}
+	rows := table.Rows
+	if rows == nil {
+		rows = [][]interface{}{}
+	}
+	writeJSON(w, map[string]interface{}{
+		"tables": []map[string]interface{}{{"name": "PrimaryResult", "columns": columns, "rows": rows}},
+	})
+}
+
+func (s *Server) serveResourceGraph(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Subscriptions []string `json:"subscriptions"`
+		Query         string   `json:"query"`
+		Options       struct {
+			Top       int    `json:"$top"`
+			SkipToken string `json:"$skipToken"`
+		} `json:"options"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Query == "" || len(body.Subscriptions) == 0 {
+		writeError(w, http.StatusBadRequest, "BadRequest", "Please provide below info when asking for support")
+		return
+	}
+
+	s.mu.Lock()
+	rows, pageSize, token := s.graphRows, s.graphPage, s.graphToken
+	s.mu.Unlock()
+	if pageSize == 0 {
+		pageSize = body.Options.Top
+	}
+	start := 0
+	if body.Options.SkipToken != "" {
+		var err error
+		if start, err = strconv.Atoi(body.Options.SkipToken); err != nil {
+			writeError(w, http.StatusBadRequest, "BadRequest", "Invalid $skipToken")
+			return
+		}
+	}
+	end := len(rows)
+	if pageSize > 0 && start+pageSize < end {
+		end = start + pageSize
+	}
+	if start > end {
+		start = end
+	}
+	page := rows[start:end]
+	if page == nil {
+		page = []map[string]interface{}{}
+	}
+	result := map[string]interface{}{
+		"totalRecords":    len(rows),
+		"count":           len(page),
+		"resultTruncated": "false",
+		"data":            page,
+	}
+	if token != "" {
+		result["$skipToken"] = token
+	} else if end < len(rows) {
+		result["$skipToken"] = strconv.Itoa(end)
+	}
+	writeJSON(w, result)
+}
+
+// metricsResponse builds the metrics response for the metrics and aggregations in the query.
+func metricsResponse(res *Resource, query url.Values) (map[string]interface{}, int, error) {
+	namespace := query.Get("metricnamespace")
+	names := strings.Split(query.Get("metricnames"), ",")
+	if len(names) > MaxMetricsPerRequest {
+		return nil, http.StatusBadRequest, fmt.Errorf("Requested metrics count: %d bigger than the supported count: %d", len(names), MaxMetricsPerRequest)
+	}
+	aggregations := strings.Split(strings.ToLower(query.Get("aggregation")), ",")
+	if query.Get("aggregation") == "" {
+		aggregations = []string{"average"}
+	}
+
+	values := []interface{}{}
+	for _, name := range names {
+		var metric *Metric
+		for i, m := range res.Metrics {
+			if strings.EqualFold(m.Name, name) && inNamespace(res, m, namespace) {
+				metric = &res.Metrics[i]
+				break
+			}
+		}
+		if metric == nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("Failed to find metric configuration for provider: %s, resource Type: %s, metric: %s",
+				strings.Split(res.Type, "/")[0], res.Type, name)
+		}
+
+		series := metric.Timeseries
+		// Without a dimension filter, Azure aggregates all timeseries of a metric into one.
+		if query.Get("$filter") == "" && len(series) > 1 {
+			series = []Timeseries{aggregateTimeseries(series)}
+		}
+		timeseries := []interface{}{}
+		for _, ts := range topTimeseries(series, query) {
+			data := []interface{}{}
+			for _, p := range ts.Data {
+				point := map[string]interface{}{"timeStamp": p.TimeStamp.UTC().Format(time.RFC3339)}
+				for _, a := range []struct {
+					name  string
+					value *float64
+				}{{"total", p.Total}, {"average", p.Average}, {"minimum", p.Minimum}, {"maximum", p.Maximum}, {"count", p.Count}} {
+					if a.value != nil && contains(aggregations, a.name) {
+						point[a.name] = *a.value
+					}
+				}
+				data = append(data, point)
+			}
+			metadata := []map[string]interface{}{}
+			for _, k := range sortedKeys(ts.Metadata) {
+				metadata = append(metadata, map[string]interface{}{
+					"name":  map[string]string{"value": k, "localizedValue": k},
+					"value": ts.Metadata[k],
+				})
+			}
+			timeseries = append(timeseries, map[string]interface{}{"metadatavalues": metadata, "data": data})
+		}
+
+		values = append(values, map[string]interface{}{
+			"id":         "/subscriptions/" + SubscriptionID + res.ID + "/providers/Microsoft.Insights/metrics/" + metric.Name,
+			"type":       "Microsoft.Insights/metrics",
+			"name":       map[string]string{"value": metric.Name, "localizedValue": metric.Name},
+			"unit":       metric.Unit,
+			"timeseries": timeseries,
+		})
+	}
+
+	return map[string]interface{}{
+		"cost":           0,
+		"timespan":       query.Get("timespan"),
+		"interval":       "PT1M",
+		"namespace":      namespace,
+		"resourceregion": res.Location,
+		"value":          values,
+	}, http.StatusOK, nil
+}
+
+// metricNamespace returns the namespace of the metric, defaulting to the resource type.
+func metricNamespace(res *Resource, m Metric) string {
+	if m.Namespace != "" {
+		return m.Namespace
+	}
+	return res.Type
+}
+
+// inNamespace returns whether the metric is part of the queried namespace,
+// the default namespace of the resource being used if it is empty.
+func inNamespace(res *Resource, m Metric, namespace string) bool {
+	if namespace == "" {
+		return m.Namespace == ""
+	}
+	return strings.EqualFold(metricNamespace(res, m), namespace)
+}
+
+// resourceType derives the resource type from a resource path.
+func resourceType(id string) string {
+	parts := strings.Split(id, "/")
+	for i, p := range parts {
+		if strings.EqualFold(p, "providers") && i+1 < len(parts) {
+			t := []string{parts[i+1]}
+			for j := i + 2; j < len(parts); j += 2 {
+				t = append(t, parts[j])
+			}
+			return strings.Join(t, "/")
+		}
+	}
+	return ""
+}
+
+// aggregateTimeseries aggregates the data points of the timeseries with the same timestamp
+// into a timeseries without dimensions. Averages are weighted by the data point counts.
+func aggregateTimeseries(timeseries []Timeseries) Timeseries {
+	var result Timeseries
+	index := map[time.Time]int{}
+	sums := map[time.Time]float64{}
+	for _, ts := range timeseries {
+		for _, p := range ts.Data {
+			i, ok := index[p.TimeStamp]
+			if !ok {
+				i = len(result.Data)
+				index[p.TimeStamp] = i
+				result.Data = append(result.Data, DataPoint{TimeStamp: p.TimeStamp})
+			}
+			a := &result.Data[i]
+			a.Total = aggregateValue(a.Total, p.Total, func(x, y float64) float64 { return x + y })
+			a.Minimum = aggregateValue(a.Minimum, p.Minimum, math.Min)
+			a.Maximum = aggregateValue(a.Maximum, p.Maximum, math.Max)
+			count := 1.0
+			if p.Count != nil {
+				count = *p.Count
+			}
+			a.Count = aggregateValue(a.Count, &count, func(x, y float64) float64 { return x + y })
+			if p.Average != nil {
+				sums[p.TimeStamp] += *p.Average * count
+				a.Average = F(sums[p.TimeStamp] / *a.Count)
+			}
+		}
+	}
+	return result
+}
+
+func aggregateValue(a, b *float64, aggregate func(x, y float64) float64) *float64 {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	return F(aggregate(*a, *b))
+}
+
+// topTimeseries sorts the timeseries by the value of the orderby aggregation in their last
+// data point, and returns the first top ones.
+func topTimeseries(timeseries []Timeseries, query url.Values) []Timeseries {
+	timeseries = append([]Timeseries(nil), timeseries...)
+	if orderby := strings.Fields(strings.ToLower(query.Get("orderby"))); len(orderby) > 0 {
+		value := func(ts Timeseries) float64 {
+			if len(ts.Data) == 0 {
+				return 0
+			}
+			p := ts.Data[len(ts.Data)-1]
+			for _, v := range []struct {
+				name  string
+				value *float64
+			}{{"total", p.Total}, {"average", p.Average}, {"minimum", p.Minimum}, {"maximum", p.Maximum}, {"count", p.Count}} {
+				if v.name == orderby[0] && v.value != nil {
+					return *v.value
+				}
+			}
+			return 0
+		}
+		desc := len(orderby) > 1 && orderby[1] == "desc"
+		sort.SliceStable(timeseries, func(i, j int) bool {
+			if desc {
+				return value(timeseries[i]) > value(timeseries[j])
+			}
+			return value(timeseries[i]) < value(timeseries[j])
+		})
+	}
+	if top, err := strconv.Atoi(query.Get("top")); err == nil && top < len(timeseries) {
+		timeseries = timeseries[:top]
+	}
+	return timeseries
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/go-kit/kit/log/level"
+)
+
+// backfillKey identifies a metric of a target.
+type backfillKey struct {
+	resource     string
+	namespace    string
+	metric       string
+	aggregations string
+}
+
+func newBackfillKey(target config.Target, namespace, metric string) backfillKey {
+	return backfillKey{
+		resource:     strings.ToLower(target.Resource),
+		namespace:    strings.ToLower(namespace),
+		metric:       strings.ToLower(metric),
+		aggregations: strings.Join(target.Aggregations, ","),
+	}
+}
+
+// backfillTracker tracks the time of the last exported data point of every metric, so the
+// next query can start from it and no data points are missed.
+type backfillTracker struct {
+	mu   sync.Mutex
+	last map[backfillKey]time.Time
+}
+
+var backfill = &backfillTracker{last: map[backfillKey]time.Time{}}
+
+// start returns the start of the time range to query the given metrics in, the time of the
+// oldest last exported data point among them. Metrics without exported data are backfilled
+// over the whole maxWindow before end.
+func (b *backfillTracker) start(target config.Target, namespace string, metrics []string, end time.Time, maxWindow time.Duration) time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	earliest := end.Add(-maxWindow)
+	start := end.Add(-time.Minute)
+	for _, m := range metrics {
+		last, ok := b.last[newBackfillKey(target, namespace, m)]
+		if !ok || last.Before(earliest) {
+			return earliest
+		}
+		if last.Before(start) {
+			start = last
+		}
+	}
+	return start
+}
+
+// update records the time of the last exported data point of the metric.
+func (b *backfillTracker) update(key backfillKey, last time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if last.After(b.last[key]) {
+		b.last[key] = last
+	}
+}
+
+// queryTimes returns the time range to query the given metrics of the target in.
+func queryTimes(c *config.Config, target config.Target, namespace string, metrics []string) (string, string) {
+	if !c.Backfill.Enabled {
+		return GetTimes()
+	}
+	end := time.Now().UTC().Add(-queryDelay)
+	start := backfill.start(target, namespace, metrics, end, time.Duration(c.Backfill.MaxWindow))
+	return end.Format(time.RFC3339), start.Format(time.RFC3339)
+}
+
+// backfillSamples returns a sample for every value of the data points, and the time of the
+// last data point having a value. The last data point is exported again by the next query,
+// as it starts from it, which ensures the most recent value is part of every collection.
+func backfillSamples(target config.Target, series metricSeries, data []AzureMetricDataPoint) ([]sample, time.Time) {
+	samples := []sample{}
+	var last time.Time
+	for _, p := range data {
+		timestamp, err := time.Parse(time.RFC3339, p.TimeStamp)
+		if err != nil {
+			level.Warn(logger).Log("msg", "Invalid data point timestamp", "target", target.Resource, "metric", series.metric, "err", err)
+			continue
+		}
+		for _, a := range metricAggregations {
+			if !hasAggregation(target, a.aggregation) {
+				continue
+			}
+			if v := p.aggregation(a.aggregation); v != nil {
+				name, labels := series.aggregation(a)
+				samples = append(samples, sample{target: target, name: name, labels: labels, value: *v * series.scale, timestamp: timestamp})
+				last = timestamp
+			}
+		}
+	}
+	return samples, last
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/azuretest"
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+)
+
+// timespan returns the start and end time of a metrics request.
+func timespan(t *testing.T, r azuretest.Request) (time.Time, time.Time) {
+	parts := strings.Split(r.Query.Get("timespan"), "/")
+	start, err := time.Parse(time.RFC3339, parts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	end, err := time.Parse(time.RFC3339, parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return start, end
+}
+
+func TestCollectBackfill(t *testing.T) {
+	base := time.Now().UTC().Truncate(time.Minute).Add(-10 * time.Minute)
+	res := azuretest.WebApp()
+	metric := azuretest.Metric{Name: "CpuTime", Unit: "Seconds", Timeseries: []azuretest.Timeseries{{}}}
+	for i := 0; i < 3; i++ {
+		metric.Timeseries[0].Data = append(metric.Timeseries[0].Data, azuretest.DataPoint{TimeStamp: base.Add(time.Duration(i) * time.Minute), Total: azuretest.F(float64(i))})
+	}
+	// Azure hasn't ingested the last minute yet.
+	metric.Timeseries[0].Data = append(metric.Timeseries[0].Data, azuretest.DataPoint{TimeStamp: base.Add(3 * time.Minute)})
+	res.Metrics = append(res.Metrics, metric)
+
+	srv := setup(t, func(srv *azuretest.Server, c *config.Config) {
+		srv.AddResource(res)
+		c.Targets[0].Aggregations = []string{"Total"}
+		c.Backfill.Enabled = true
+	}, webAppTarget("CpuTime"))
+	defer srv.Close()
+
+	samples, err := collectTarget(sc.C, sc.C.Targets[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("Expected a sample per data point with a value, got %v", samples)
+	}
+	for i, s := range samples {
+		if s.name != "cputime_seconds_total" || s.value != float64(i) || !s.timestamp.Equal(base.Add(time.Duration(i)*time.Minute)) {
+			t.Errorf("Unexpected sample %d: %v", i, s)
+		}
+	}
+	// Without previous data, the whole maximum window is queried.
+	start, end := timespan(t, srv.Requests()[0])
+	if end.Sub(start) != time.Duration(config.DefaultBackfillMaxWindow) {
+		t.Errorf("Expected the first request to span the maximum backfill window, got %v to %v", start, end)
+	}
+
+	// The missing data point was ingested, and another one added.
+	data := res.Metrics[len(res.Metrics)-1].Timeseries[0].Data
+	data[3].Total = azuretest.F(3)
+	res.Metrics[len(res.Metrics)-1].Timeseries[0].Data = append(data, azuretest.DataPoint{TimeStamp: base.Add(4 * time.Minute), Total: azuretest.F(4)})
+	srv.AddResource(res)
+
+	want := fmt.Sprintf(`cputime_seconds_total{resource_group="blog-group",resource_name="blog"} 4 %d`, base.Add(4*time.Minute).UnixNano()/int64(time.Millisecond))
+	assertSamples(t, scrape(t), want)
+	start, _ = timespan(t, srv.Requests()[1])
+	if !start.Equal(base.Add(2 * time.Minute)) {
+		t.Errorf("Expected the second request to start at the last exported data point %v, got %v", base.Add(2*time.Minute), start)
+	}
+}
+
+func TestBackfillStart(t *testing.T) {
+	b := &backfillTracker{last: map[backfillKey]time.Time{}}
+	target := webAppTarget("A", "B")
+	end := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	window := time.Hour
+
+	if got := b.start(target, "", []string{"A", "B"}, end, window); !got.Equal(end.Add(-window)) {
+		t.Errorf("Expected metrics without data to be backfilled over the window, got %v", got)
+	}
+
+	b.update(newBackfillKey(target, "", "a"), end.Add(-10*time.Minute))
+	b.update(newBackfillKey(target, "", "B"), end.Add(-20*time.Minute))
+	if got := b.start(target, "", []string{"A", "B"}, end, window); !got.Equal(end.Add(-20 * time.Minute)) {
+		t.Errorf("Expected the oldest last data point, got %v", got)
+	}
+	if got := b.start(target, "", []string{"A"}, end, window); !got.Equal(end.Add(-10 * time.Minute)) {
+		t.Errorf("Expected the last data point of A, got %v", got)
+	}
+	// Updates never go back in time.
+	b.update(newBackfillKey(target, "", "A"), end.Add(-time.Hour))
+	if got := b.start(target, "", []string{"A"}, end, window); !got.Equal(end.Add(-10 * time.Minute)) {
+		t.Errorf("Expected the last data point of A, got %v", got)
+	}
+
+	b.update(newBackfillKey(target, "", "C"), end.Add(-2*time.Hour))
+	if got := b.start(target, "", []string{"A", "C"}, end, window); !got.Equal(end.Add(-window)) {
+		t.Errorf("Expected the backfill to be bounded by the window, got %v", got)
+	}
+}
+
+func TestCollectBackfillSplit(t *testing.T) {
+	base := time.Now().UTC().Truncate(time.Minute).Add(-10 * time.Minute)
+	res := azuretest.WebApp()
+	metric := azuretest.Metric{Name: "CpuTime", Unit: "Seconds", Dimensions: []string{"Instance"}}
+	for instance, points := range map[string]int{"instance-1": 3, "instance-2": 1} {
+		ts := azuretest.Timeseries{Metadata: map[string]string{"instance": instance}}
+		for i := 0; i < points; i++ {
+			ts.Data = append(ts.Data, azuretest.DataPoint{TimeStamp: base.Add(time.Duration(i) * time.Minute), Total: azuretest.F(float64(i))})
+		}
+		metric.Timeseries = append(metric.Timeseries, ts)
+	}
+	res.Metrics = append(res.Metrics, metric)
+
+	target := config.Target{
+		Resource:     azuretest.WebAppResource,
+		Aggregations: []string{"Total"},
+		Metrics:      []config.Metric{{Name: "CpuTime", Dimensions: []string{"Instance"}}},
+	}
+	srv := setup(t, func(srv *azuretest.Server, c *config.Config) {
+		srv.AddResource(res)
+		c.Backfill.Enabled = true
+	}, target)
+	defer srv.Close()
+
+	if _, err := collectTarget(sc.C, sc.C.Targets[0]); err != nil {
+		t.Fatal(err)
+	}
+	// The next query starts from the last data point of the series lagging the most.
+	if got := backfill.last[newBackfillKey(sc.C.Targets[0], "", "CpuTime")]; !got.Equal(base) {
+		t.Errorf("Expected the last data point of the lagging series %v, got %v", base, got)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sample is a single exported value of a background query. The metric relabel configs of
+// the target apply to it, if set.
+type sample struct {
+	target config.Target
+	name   string
+	labels map[string]string
+	value  float64
+	// timestamp is the time of the Azure data point, zero if the sample is exported without one.
+	timestamp time.Time
+	// region is the Azure region of the target resource, if known.
+	region string
+}
+
+// backgroundQuery is run on an interval independent of scrapes. The samples of its last
+// successful run are exported on every scrape.
+type backgroundQuery struct {
+	name     string
+	interval time.Duration
+	// offset delays the first run, to spread the queries over their interval.
+	offset time.Duration
+	run    func() ([]sample, error)
+	// resource is the target resource the query collects the metrics of, empty for other
+	// queries. The age of the samples of target queries is exported.
+	resource string
+	// target is the target the query collects the metrics of, nil for other queries.
+	target *config.Target
+	// config is the configuration the query was created from, used for all of its runs.
+	config *config.Config
+
+	mu      sync.RWMutex
+	samples []sample
+	updated time.Time
+}
+
+// update runs the query, replaces the cached samples and pushes them to the remote write
+// endpoints and the OTLP collector. If the query fails, the previous samples are kept.
+func (q *backgroundQuery) update() {
+	samples, err := q.run()
+	if err != nil {
+		level.Error(logger).Log(append([]interface{}{"msg", "Failed to run " + q.name}, errorFields(err)...)...)
+		return
+	}
+	now := time.Now()
+	q.mu.Lock()
+	q.samples = samples
+	q.updated = now
+	q.mu.Unlock()
+	rw.push(q.config, samples, now)
+	oe.push(q.config, samples, now)
+}
+
+func (q *backgroundQuery) loop(stop <-chan struct{}) {
+	if q.offset > 0 {
+		timer := time.NewTimer(q.offset)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+	q.update()
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			q.update()
+		}
+	}
+}
+
+// backgroundQueries holds the background queries of the current configuration.
+type backgroundQueries struct {
+	mu      sync.Mutex
+	queries []*backgroundQuery
+	stop    chan struct{}
+}
+
+var bq = &backgroundQueries{}
+
+// newBackgroundQueries returns the background queries of the given configuration.
+func newBackgroundQueries(c *config.Config) []*backgroundQuery {
+	queries := []*backgroundQuery{}
+	for _, q := range c.LogQueries {
+		queries = append(queries, newLogQuery(c, q))
+	}
+	for _, q := range c.ResourceGraphQueries {
+		queries = append(queries, newResourceGraphQuery(c, q))
+	}
+	if c.ResourceHealth.Enabled {
+		queries = append(queries, newResourceHealthQuery(c))
+	}
+	if c.Cost.Enabled {
+		queries = append(queries, newCostQuery(c))
+	}
+	if *collectionMode == collectionModeAsync {
+		queries = append(queries, newTargetQueries(c)...)
+	}
+	for _, q := range queries {
+		q.config = c
+	}
+	return queries
+}
+
+// set replaces the background queries, stopping the running ones.
+func (b *backgroundQueries) set(queries []*backgroundQuery) chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stop != nil {
+		close(b.stop)
+	}
+	b.stop = make(chan struct{})
+	b.queries = queries
+	return b.stop
+}
+
+// start replaces the background queries with the ones of the given configuration
+// and starts running them. The new queries of unchanged targets keep the samples of the
+// previous ones until they ran.
+func (b *backgroundQueries) start(c *config.Config) {
+	queries := newBackgroundQueries(c)
+	b.mu.Lock()
+	prev := b.queries
+	b.mu.Unlock()
+	carrySamples(prev, queries)
+	stop := b.set(queries)
+	for _, q := range queries {
+		go q.loop(stop)
+	}
+}
+
+// carrySamples copies the samples of the previous target queries to the new queries of the same
+// target, if the settings their samples depend on are unchanged.
+func carrySamples(prev, queries []*backgroundQuery) {
+	for _, q := range queries {
+		if q.target == nil {
+			continue
+		}
+		for _, p := range prev {
+			if p.target == nil || !reflect.DeepEqual(*p.target, *q.target) || !sameSampleSettings(p.config, q.config) {
+				continue
+			}
+			p.mu.RLock()
+			q.samples, q.updated = p.samples, p.updated
+			p.mu.RUnlock()
+			break
+		}
+	}
+}
+
+// sameSampleSettings returns whether target samples collected with either configuration are
+// named and labeled the same.
+func sameSampleSettings(a, b *config.Config) bool {
+	return reflect.DeepEqual(a.Labels, b.Labels) && a.MetricNaming == b.MetricNaming &&
+		a.NormalizeUnits == b.NormalizeUnits && a.MaxSeries == b.MaxSeries
+}
+
+// collect adds the cached samples of all background queries to the metric sender, and sends
+// the age of the samples of every target resource.
+func (b *backgroundQueries) collect(ch chan<- prometheus.Metric, m *metricSender) {
+	b.mu.Lock()
+	queries := b.queries
+	b.mu.Unlock()
+
+	now := time.Now()
+	ages := map[string]float64{}
+	for _, q := range queries {
+		q.mu.RLock()
+		for _, s := range latestSamples(q.samples) {
+			m.add(s)
+		}
+		if q.resource != "" && !q.updated.IsZero() {
+			// Report the oldest samples of resources that are part of several targets.
+			age := now.Sub(q.updated).Seconds()
+			if oldest, ok := ages[q.resource]; !ok || age > oldest {
+				ages[q.resource] = age
+			}
+		}
+		q.mu.RUnlock()
+	}
+	for resource, age := range ages {
+		ch <- prometheus.MustNewConstMetric(targetDataAgeDesc, prometheus.GaugeValue, age, resource)
+	}
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// DefaultBackfillMaxWindow is the maximum time range backfilled if none is configured.
+var DefaultBackfillMaxWindow = model.Duration(time.Hour)
+
+// BackfillConfig - querying the data points missed since the last successful collection.
+type BackfillConfig struct {
+	Enabled   bool           `yaml:"enabled"`
+	MaxWindow model.Duration `yaml:"max_window,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *BackfillConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain BackfillConfig
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "backfill"); err != nil {
+		return err
+	}
+	return nil
+}
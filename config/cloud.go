@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Supported Azure clouds.
+const (
+	CloudAzurePublic       = "AzurePublic"
+	CloudAzureChina        = "AzureChina"
+	CloudAzureUSGovernment = "AzureUSGovernment"
+	CloudCustom            = "custom"
+)
+
+// CloudEndpoints - Azure endpoints used by the exporter.
+type CloudEndpoints struct {
+	// ActiveDirectory is the authority used to request access tokens.
+	ActiveDirectory string `yaml:"active_directory,omitempty"`
+	// ResourceManager is the Azure Resource Manager endpoint, also used as token audience.
+	ResourceManager string `yaml:"resource_manager,omitempty"`
+	// Monitor is the endpoint used for Azure Monitor queries, defaults to ResourceManager.
+	Monitor string `yaml:"monitor,omitempty"`
+	// LogAnalytics is the Log Analytics query API endpoint, also used as token audience for log queries.
+	LogAnalytics string `yaml:"log_analytics,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// Clouds holds the endpoints of the known Azure clouds.
+var Clouds = map[string]CloudEndpoints{
+	CloudAzurePublic: {
+		ActiveDirectory: "https://login.microsoftonline.com",
+		ResourceManager: "https://management.azure.com",
+		LogAnalytics:    "https://api.loganalytics.io",
+	},
+	CloudAzureChina: {
+		ActiveDirectory: "https://login.chinacloudapi.cn",
+		ResourceManager: "https://management.chinacloudapi.cn",
+		LogAnalytics:    "https://api.loganalytics.azure.cn",
+	},
+	CloudAzureUSGovernment: {
+		ActiveDirectory: "https://login.microsoftonline.us",
+		ResourceManager: "https://management.usgovcloudapi.net",
+		LogAnalytics:    "https://api.loganalytics.us",
+	},
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *CloudEndpoints) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain CloudEndpoints
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "endpoints"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateCloud fills in the endpoints of the configured cloud which aren't explicitly set.
+func (c *Config) validateCloud() error {
+	if c.Cloud == "" {
+		c.Cloud = CloudAzurePublic
+	}
+
+	if c.Cloud != CloudCustom {
+		defaults, ok := Clouds[c.Cloud]
+		if !ok {
+			return fmt.Errorf("%s is not one of the valid clouds (%s, %s, %s, %s)", c.Cloud, CloudAzurePublic, CloudAzureChina, CloudAzureUSGovernment, CloudCustom)
+		}
+		if c.Endpoints.ActiveDirectory == "" {
+			c.Endpoints.ActiveDirectory = defaults.ActiveDirectory
+		}
+		if c.Endpoints.ResourceManager == "" {
+			c.Endpoints.ResourceManager = defaults.ResourceManager
+		}
+		if c.Endpoints.LogAnalytics == "" {
+			c.Endpoints.LogAnalytics = defaults.LogAnalytics
+		}
+	}
+	if c.Endpoints.Monitor == "" {
+		c.Endpoints.Monitor = c.Endpoints.ResourceManager
+	}
+
+	endpoints := map[string]*string{
+		"active_directory": &c.Endpoints.ActiveDirectory,
+		"resource_manager": &c.Endpoints.ResourceManager,
+		"monitor":          &c.Endpoints.Monitor,
+	}
+	// The Log Analytics endpoint is only needed for log queries.
+	if c.Endpoints.LogAnalytics != "" || len(c.LogQueries) > 0 {
+		endpoints["log_analytics"] = &c.Endpoints.LogAnalytics
+	}
+	for name, endpoint := range endpoints {
+		if *endpoint == "" {
+			return fmt.Errorf("Endpoint %s must be set for the %s cloud", name, c.Cloud)
+		}
+		u, err := url.Parse(*endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("Endpoint %s %q is not a valid URL", name, *endpoint)
+		}
+		*endpoint = strings.TrimSuffix(*endpoint, "/")
+	}
+	return nil
+}
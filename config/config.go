@@ -3,16 +3,67 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
+	"unicode"
 
+	"github.com/prometheus/common/model"
 	yaml "gopkg.in/yaml.v2"
 )
 
 // Config - Azure exporter configuration
 type Config struct {
-	Credentials Credentials `yaml:"credentials"`
-	Targets     []Target    `yaml:"targets"`
+	Credentials Credentials       `yaml:"credentials"`
+	Targets     []Target          `yaml:"targets"`
+	Labels      map[string]string `yaml:"labels"`
+
+	// CredentialProfiles are named credentials that targets can refer to.
+	CredentialProfiles map[string]Credentials `yaml:"credential_profiles,omitempty"`
+
+	// Cloud selects the Azure cloud to talk to, Endpoints overrides its endpoints.
+	Cloud     string         `yaml:"cloud"`
+	Endpoints CloudEndpoints `yaml:"endpoints"`
+
+	HTTPClient HTTPClientConfig `yaml:"http_client"`
+
+	// ValidationMode controls what happens when configured metrics don't match
+	// the metric definitions of their resource.
+	ValidationMode string `yaml:"validation_mode"`
+
+	MetricRelabelConfigs []*RelabelConfig `yaml:"metric_relabel_configs"`
+
+	// MetricNaming is how Azure metrics are mapped to metric names, for targets not setting it.
+	MetricNaming string `yaml:"metric_naming,omitempty"`
+
+	// NormalizeUnits converts metric values to Prometheus base units, and names metrics after them.
+	NormalizeUnits bool `yaml:"normalize_units,omitempty"`
+
+	// MaxSeries is the number of series exported per metric split by dimensions, for metrics
+	// not setting it. It limits every metric of every target on its own. Unlimited if 0.
+	MaxSeries int `yaml:"max_series,omitempty"`
+
+	// LogQueries are Log Analytics queries run in the background.
+	LogQueries []*LogQuery `yaml:"log_queries,omitempty"`
+
+	// ResourceGraphQueries are Azure Resource Graph queries run in the background.
+	ResourceGraphQueries []*ResourceGraphQuery `yaml:"resource_graph_queries,omitempty"`
+
+	// ResourceHealth enables the export of the availability status of the targets.
+	ResourceHealth ResourceHealthConfig `yaml:"resource_health,omitempty"`
+
+	// Cost enables the export of Cost Management spend.
+	Cost CostConfig `yaml:"cost,omitempty"`
+
+	// Backfill enables querying the metric data points missed since the last collection.
+	Backfill BackfillConfig `yaml:"backfill,omitempty"`
+
+	// RemoteWrite are Prometheus remote write endpoints the collected samples are pushed to.
+	RemoteWrite []*RemoteWriteConfig `yaml:"remote_write,omitempty"`
+
+	// OTLP is an OpenTelemetry collector the collected samples are pushed to.
+	OTLP *OTLPConfig `yaml:"otlp,omitempty"`
 
 	// Catches all undefined fields and must be empty after parsing.
 	XXX map[string]interface{} `yaml:",inline"`
@@ -24,8 +75,18 @@ type SafeConfig struct {
 	C *Config
 }
 
+// Get returns the current configuration. Readers must not modify it, and should use the
+// same configuration for a whole collection rather than calling Get repeatedly.
+func (sc *SafeConfig) Get() *Config {
+	sc.RLock()
+	defer sc.RUnlock()
+	return sc.C
+}
+
 // ReloadConfig - allows for live reloads of the configuration file.
-func (sc *SafeConfig) ReloadConfig(confFile string) (err error) {
+// The optional checks are run against the parsed configuration before it becomes active,
+// and may modify it.
+func (sc *SafeConfig) ReloadConfig(confFile string, checks ...func(*Config) error) (err error) {
 	var c = &Config{}
 
 	yamlFile, err := ioutil.ReadFile(confFile)
@@ -41,6 +102,12 @@ func (sc *SafeConfig) ReloadConfig(confFile string) (err error) {
 		return fmt.Errorf("Error validating config file: %s", err)
 	}
 
+	for _, check := range checks {
+		if err := check(c); err != nil {
+			return fmt.Errorf("Error validating config file: %s", err)
+		}
+	}
+
 	sc.Lock()
 	sc.C = c
 	sc.Unlock()
@@ -48,56 +115,322 @@ func (sc *SafeConfig) ReloadConfig(confFile string) (err error) {
 	return nil
 }
 
-var validAggregations = []string{"Total", "Average", "Minimum", "Maximum"}
+// ValidAggregations are the aggregations that can be requested for a target.
+var ValidAggregations = []string{"Total", "Average", "Minimum", "Maximum"}
+
+// Metric validation modes.
+const (
+	// ValidationWarn logs invalid metrics but keeps querying them.
+	ValidationWarn = "warn"
+	// ValidationFail refuses to load a configuration containing invalid metrics.
+	ValidationFail = "fail"
+	// ValidationDrop logs and removes invalid metrics from their targets.
+	ValidationDrop = "drop"
+	// ValidationNone skips the validation of metrics against their definitions.
+	ValidationNone = "none"
+)
+
+// Metric naming modes.
+const (
+	// MetricNamingName puts the metric name, unit and aggregation into the metric name,
+	// e.g. http5xx_count_total.
+	MetricNamingName = "name"
+	// MetricNamingLabels exports all metrics as azure_monitor_metric, with the metric name,
+	// unit and aggregation as labels.
+	MetricNamingLabels = "labels"
+)
+
+func validateMetricNaming(naming string) error {
+	switch naming {
+	case MetricNamingName, MetricNamingLabels:
+		return nil
+	default:
+		return fmt.Errorf("%s is not one of the valid metric naming modes (%s, %s)", naming, MetricNamingName, MetricNamingLabels)
+	}
+}
+
+// DefaultTargetInterval is the interval targets are queried on in the async collection mode if none is configured.
+var DefaultTargetInterval = model.Duration(time.Minute)
+
+// reservedLabels are set by the exporter itself and cannot be overridden by static labels.
+var reservedLabels = []string{"resource_group", "resource_name", "metric", "metric_namespace", "unit", "aggregation"}
+
+func validateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return fmt.Errorf("%q is not a valid label name", name)
+		}
+		for _, reserved := range reservedLabels {
+			if name == reserved {
+				return fmt.Errorf("Label %q is reserved and cannot be set in the configuration", name)
+			}
+		}
+	}
+	return nil
+}
 
 func (c *Config) Validate() (err error) {
+	switch c.ValidationMode {
+	case "":
+		c.ValidationMode = ValidationWarn
+	case ValidationWarn, ValidationFail, ValidationDrop, ValidationNone:
+	default:
+		return fmt.Errorf("%s is not one of the valid validation modes (%s, %s, %s, %s)", c.ValidationMode, ValidationWarn, ValidationFail, ValidationDrop, ValidationNone)
+	}
+
+	if err := c.validateCloud(); err != nil {
+		return err
+	}
+
+	if err := c.HTTPClient.validate(); err != nil {
+		return err
+	}
+
+	if err := validateLabels(c.Labels); err != nil {
+		return err
+	}
+
+	if c.MetricNaming == "" {
+		c.MetricNaming = MetricNamingName
+	}
+	if err := validateMetricNaming(c.MetricNaming); err != nil {
+		return err
+	}
+
+	if c.MaxSeries < 0 {
+		return fmt.Errorf("max_series must not be negative")
+	}
+
+	for i := range c.Targets {
+		if c.Targets[i].Interval == 0 {
+			c.Targets[i].Interval = DefaultTargetInterval
+		}
+		if c.Targets[i].MetricNaming == "" {
+			c.Targets[i].MetricNaming = c.MetricNaming
+		}
+		for j, m := range c.Targets[i].Metrics {
+			if len(m.Dimensions) > 0 && m.MaxSeries == 0 {
+				c.Targets[i].Metrics[j].MaxSeries = c.MaxSeries
+			}
+		}
+	}
+
 	for _, t := range c.Targets {
 		for _, a := range t.Aggregations {
 			ok := false
-			for _, valid := range validAggregations {
+			for _, valid := range ValidAggregations {
 				if a == valid {
 					ok = true
 					break
 				}
 			}
 			if !ok {
-				return fmt.Errorf("%s is not one of the valid aggregations (%v)", a, validAggregations)
+				return fmt.Errorf("%s is not one of the valid aggregations (%v)", a, ValidAggregations)
 			}
 		}
 
 		if !strings.HasPrefix(t.Resource, "/") {
 			return fmt.Errorf("Resource path %q must start with a /", t.Resource)
 		}
+
+		if err := validateLabels(t.Labels); err != nil {
+			return fmt.Errorf("Invalid labels for resource %q: %s", t.Resource, err)
+		}
+
+		if err := validateMetricNaming(t.MetricNaming); err != nil {
+			return fmt.Errorf("Invalid metric naming for resource %q: %s", t.Resource, err)
+		}
+
+		for _, m := range t.Metrics {
+			if err := m.validate(c.Labels, t.Labels); err != nil {
+				return fmt.Errorf("Invalid metric %q for resource %q: %s", m.Name, t.Resource, err)
+			}
+		}
+
+		if _, ok := c.CredentialProfiles[t.Credentials]; t.Credentials != "" && !ok {
+			return fmt.Errorf("Unknown credentials profile %q for resource %q", t.Credentials, t.Resource)
+		}
+	}
+
+	logQueries := map[string]bool{}
+	for _, q := range c.LogQueries {
+		if err := q.validate(c); err != nil {
+			return fmt.Errorf("Invalid log query %q: %s", q.Name, err)
+		}
+		if logQueries[q.Name] {
+			return fmt.Errorf("Duplicate log query name %q", q.Name)
+		}
+		logQueries[q.Name] = true
+	}
+
+	if c.ResourceHealth.Interval == 0 {
+		c.ResourceHealth.Interval = DefaultResourceHealthInterval
+	}
+
+	if c.Backfill.MaxWindow == 0 {
+		c.Backfill.MaxWindow = DefaultBackfillMaxWindow
+	}
+
+	if err := c.Cost.validate(c); err != nil {
+		return fmt.Errorf("Invalid cost configuration: %s", err)
+	}
+
+	graphQueries := map[string]bool{}
+	for _, q := range c.ResourceGraphQueries {
+		if err := q.validate(c); err != nil {
+			return fmt.Errorf("Invalid Resource Graph query %q: %s", q.Name, err)
+		}
+		if graphQueries[q.Name] {
+			return fmt.Errorf("Duplicate Resource Graph query name %q", q.Name)
+		}
+		graphQueries[q.Name] = true
+	}
+
+	for _, rw := range c.RemoteWrite {
+		if err := rw.validate(); err != nil {
+			return fmt.Errorf("Invalid remote write configuration: %s", err)
+		}
+	}
+
+	if c.OTLP != nil {
+		if err := c.OTLP.validate(); err != nil {
+			return fmt.Errorf("Invalid OTLP configuration: %s", err)
+		}
 	}
 	return nil
 }
 
+// TargetCredentials returns the credentials to use for the target.
+func (c *Config) TargetCredentials(t Target) Credentials {
+	return c.ProfileCredentials(t.Credentials)
+}
+
+// ProfileCredentials returns the credentials of the named profile, the top level
+// credentials if the name is empty.
+func (c *Config) ProfileCredentials(name string) Credentials {
+	if name != "" {
+		return c.CredentialProfiles[name]
+	}
+	return c.Credentials
+}
+
 // Credentials - Azure credentials
+// Values may reference environment variables as ${VAR}.
 type Credentials struct {
-	SubscriptionID string `yaml:"subscription_id"`
-	ClientID       string `yaml:"client_id"`
-	ClientSecret   string `yaml:"client_secret"`
-	TenantID       string `yaml:"tenant_id"`
+	SubscriptionID   string `yaml:"subscription_id"`
+	ClientID         string `yaml:"client_id"`
+	ClientSecret     Secret `yaml:"client_secret,omitempty"`
+	ClientSecretFile string `yaml:"client_secret_file,omitempty"`
+	TenantID         string `yaml:"tenant_id"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
 
 // Target represents Azure target resource and its associated metric definitions
 type Target struct {
-	Resource     string   `yaml:"resource"`
-	Metrics      []Metric `yaml:"metrics"`
-	Aggregations []string `yaml:"aggregations"`
+	Resource     string            `yaml:"resource"`
+	Metrics      []Metric          `yaml:"metrics"`
+	Aggregations []string          `yaml:"aggregations,omitempty"`
+	Labels       map[string]string `yaml:"labels,omitempty"`
+
+	// Credentials is the name of the credentials profile to use, the top level credentials are used if empty.
+	Credentials string `yaml:"credentials,omitempty"`
+
+	// MetricNamespace is the default metric namespace of the target's metrics.
+	MetricNamespace string `yaml:"metric_namespace,omitempty"`
+
+	// Interval is the interval the target is queried on in the async collection mode.
+	Interval model.Duration `yaml:"interval,omitempty"`
+
+	// MetricNaming is how the target's metrics are mapped to metric names, the top level
+	// metric_naming if empty.
+	MetricNaming string `yaml:"metric_naming,omitempty"`
+
+	MetricRelabelConfigs []*RelabelConfig `yaml:"metric_relabel_configs,omitempty"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
 
-// Metric defines metric name
+// Metric defines metric name and optionally the metric namespace it belongs to
 type Metric struct {
-	Name string `yaml:"name"`
+	Name            string `yaml:"name"`
+	MetricNamespace string `yaml:"metric_namespace,omitempty"`
+
+	// Dimensions split the metric into a timeseries per combination of their values.
+	Dimensions []string `yaml:"dimensions,omitempty"`
+	// Top is the number of timeseries Azure returns for a split metric, 10 by default.
+	Top int `yaml:"top,omitempty"`
+	// OrderBy is the aggregation and direction the timeseries are sorted by to select the top
+	// ones, e.g. "Total desc".
+	OrderBy string `yaml:"orderby,omitempty"`
+	// MaxSeries is the number of timeseries exported for this metric, further ones are
+	// aggregated into a single series with all dimensions set to "other". It doesn't limit
+	// the other metrics of the target. Unlimited if 0.
+	MaxSeries int `yaml:"max_series,omitempty"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
 
+// validate checks the metric. The dimension labels of split metrics must not clash with the
+// labels set by the exporter or the given static labels.
+func (m Metric) validate(static ...map[string]string) error {
+	if len(m.Dimensions) == 0 {
+		if m.Top != 0 || m.OrderBy != "" || m.MaxSeries != 0 {
+			return fmt.Errorf("top, orderby and max_series require dimensions")
+		}
+		return nil
+	}
+	for _, d := range m.Dimensions {
+		if d == "" || strings.ContainsAny(d, "'") {
+			return fmt.Errorf("Invalid dimension %q", d)
+		}
+		name := DimensionLabelName(d)
+		for _, reserved := range reservedLabels {
+			if name == reserved {
+				return fmt.Errorf("Label %q of dimension %q is reserved", name, d)
+			}
+		}
+		for _, labels := range static {
+			if _, ok := labels[name]; ok {
+				return fmt.Errorf("Label %q of dimension %q clashes with a static label", name, d)
+			}
+		}
+	}
+	if m.Top < 0 || m.MaxSeries < 0 {
+		return fmt.Errorf("top and max_series must not be negative")
+	}
+	if m.OrderBy != "" {
+		fields := strings.Fields(m.OrderBy)
+		if len(fields) > 2 || (len(fields) == 2 && !strings.EqualFold(fields[1], "asc") && !strings.EqualFold(fields[1], "desc")) {
+			return fmt.Errorf("orderby %q must be an aggregation followed by asc or desc", m.OrderBy)
+		}
+	}
+	return nil
+}
+
+// invalidLabelNameChars are the characters replaced in label names converted from Azure names.
+var invalidLabelNameChars = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// DimensionLabelName converts a Cost Management or metric dimension name to a label name,
+// e.g. ResourceGroupName to resource_group_name. Names starting with a digit are prefixed
+// with an underscore.
+func DimensionLabelName(dimension string) string {
+	var b strings.Builder
+	prev := ' '
+	for _, r := range dimension {
+		if unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) {
+			b.WriteRune('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+		prev = r
+	}
+	name := invalidLabelNameChars.ReplaceAllString(b.String(), "_")
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
 func checkOverflow(m map[string]interface{}, ctx string) error {
 	if len(m) > 0 {
 		var keys []string
@@ -109,6 +442,15 @@ func checkOverflow(m map[string]interface{}, ctx string) error {
 	return nil
 }
 
+// String returns the configuration as YAML, with secrets redacted.
+func (s Config) String() string {
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Sprintf("<error creating config string: %s>", err)
+	}
+	return string(b)
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
 func (s *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	type plain Config
@@ -130,6 +472,12 @@ func (s *Credentials) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := checkOverflow(s.XXX, "config"); err != nil {
 		return err
 	}
+	if err := s.expandEnv(); err != nil {
+		return err
+	}
+	if s.ClientSecret != "" && s.ClientSecretFile != "" {
+		return fmt.Errorf("at most one of client_secret and client_secret_file must be set")
+	}
 	return nil
 }
 
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// DefaultCostInterval is the interval costs are queried on if none is configured. Cost data is
+// only updated a few times a day.
+var DefaultCostInterval = model.Duration(4 * time.Hour)
+
+// Cost types that can be queried.
+const (
+	CostTypeActual    = "ActualCost"
+	CostTypeAmortized = "AmortizedCost"
+)
+
+// CostTagPrefix marks groupings by tag in CostConfig.GroupBy.
+const CostTagPrefix = "tag:"
+
+// CostConfig - export of Cost Management spend.
+type CostConfig struct {
+	Enabled  bool           `yaml:"enabled"`
+	Interval model.Duration `yaml:"interval,omitempty"`
+	Type     string         `yaml:"type,omitempty"`
+
+	// Scopes to query, e.g. /subscriptions/<id> or /subscriptions/<id>/resourceGroups/<name>.
+	// The subscription of the credentials is used if empty.
+	Scopes []string `yaml:"scopes,omitempty"`
+	// GroupBy are the dimensions to group costs by, e.g. ResourceGroupName or ServiceName,
+	// or tag:<key> to group by a tag.
+	GroupBy []string `yaml:"group_by,omitempty"`
+
+	// Credentials is the name of the credentials profile to use, the top level credentials are used if empty.
+	Credentials string `yaml:"credentials,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *CostConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain CostConfig
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "cost"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validate checks the cost configuration and fills in defaults.
+func (s *CostConfig) validate(c *Config) error {
+	if s.Interval == 0 {
+		s.Interval = DefaultCostInterval
+	}
+	switch s.Type {
+	case "":
+		s.Type = CostTypeActual
+	case CostTypeActual, CostTypeAmortized:
+	default:
+		return fmt.Errorf("%s is not one of the valid cost types (%s, %s)", s.Type, CostTypeActual, CostTypeAmortized)
+	}
+	if !s.Enabled {
+		return nil
+	}
+
+	for _, scope := range s.Scopes {
+		if !strings.HasPrefix(scope, "/") {
+			return fmt.Errorf("Scope %q must start with a /", scope)
+		}
+	}
+	if _, ok := c.CredentialProfiles[s.Credentials]; s.Credentials != "" && !ok {
+		return fmt.Errorf("Unknown credentials profile %q", s.Credentials)
+	}
+	if len(s.Scopes) == 0 && c.ProfileCredentials(s.Credentials).SubscriptionID == "" {
+		return fmt.Errorf("scopes must be set if the credentials have no subscription_id")
+	}
+
+	// The Cost Management API allows two groupings, and returns a single tag column.
+	if len(s.GroupBy) > 2 {
+		return fmt.Errorf("At most two group_by dimensions are supported")
+	}
+	tags := 0
+	for _, g := range s.GroupBy {
+		if strings.HasPrefix(g, CostTagPrefix) {
+			tags++
+			g = strings.TrimPrefix(g, CostTagPrefix)
+		}
+		if g == "" {
+			return fmt.Errorf("Empty group_by dimension")
+		}
+	}
+	if tags > 1 {
+		return fmt.Errorf("At most one tag can be used in group_by")
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// DefaultResourceGraphQueryInterval is the interval Resource Graph queries are run on if none is configured.
+var DefaultResourceGraphQueryInterval = model.Duration(15 * time.Minute)
+
+// ResourceGraphQuery - Azure Resource Graph query run on an interval, exporting a gauge per result row.
+type ResourceGraphQuery struct {
+	// Name is the name of the exported metric.
+	Name  string `yaml:"name"`
+	Query string `yaml:"query"`
+	// Subscriptions to query, the subscription of the credentials is used if empty.
+	Subscriptions []string       `yaml:"subscriptions,omitempty"`
+	Interval      model.Duration `yaml:"interval,omitempty"`
+
+	// ValueColumn is the result column holding the value of the gauge.
+	ValueColumn string `yaml:"value_column"`
+	// LabelColumns are the result columns exported as labels of the same name.
+	LabelColumns []string          `yaml:"label_columns,omitempty"`
+	Labels       map[string]string `yaml:"labels,omitempty"`
+
+	// Credentials is the name of the credentials profile to use, the top level credentials are used if empty.
+	Credentials string `yaml:"credentials,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *ResourceGraphQuery) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ResourceGraphQuery
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "resource_graph_queries"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validate checks the Resource Graph query and fills in defaults.
+func (q *ResourceGraphQuery) validate(c *Config) error {
+	if !model.IsValidMetricName(model.LabelValue(q.Name)) {
+		return fmt.Errorf("%q is not a valid metric name", q.Name)
+	}
+	if q.Query == "" {
+		return fmt.Errorf("query must be set")
+	}
+	if err := validateColumns(q.ValueColumn, q.LabelColumns, c.Labels, q.Labels); err != nil {
+		return err
+	}
+	if q.Interval == 0 {
+		q.Interval = DefaultResourceGraphQueryInterval
+	}
+	if err := validateLabels(q.Labels); err != nil {
+		return err
+	}
+	if _, ok := c.CredentialProfiles[q.Credentials]; q.Credentials != "" && !ok {
+		return fmt.Errorf("Unknown credentials profile %q", q.Credentials)
+	}
+	if len(q.Subscriptions) == 0 && c.ProfileCredentials(q.Credentials).SubscriptionID == "" {
+		return fmt.Errorf("subscriptions must be set if the credentials have no subscription_id")
+	}
+	return nil
+}
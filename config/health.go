@@ -0,0 +1,30 @@
+package config
+
+import (
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// DefaultResourceHealthInterval is the interval the availability statuses are fetched on if none is configured.
+var DefaultResourceHealthInterval = model.Duration(5 * time.Minute)
+
+// ResourceHealthConfig - export of the Resource Health availability status of the targets.
+type ResourceHealthConfig struct {
+	Enabled  bool           `yaml:"enabled"`
+	Interval model.Duration `yaml:"interval,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *ResourceHealthConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ResourceHealthConfig
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "resource_health"); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// DefaultHTTPClientConfig is the default configuration of the client used to talk to Azure.
+var DefaultHTTPClientConfig = HTTPClientConfig{
+	Timeout:             model.Duration(60 * time.Second),
+	DialTimeout:         model.Duration(30 * time.Second),
+	TLSHandshakeTimeout: model.Duration(10 * time.Second),
+	IdleConnTimeout:     model.Duration(90 * time.Second),
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 10,
+}
+
+// HTTPClientConfig - configuration of the HTTP client used for token and API requests.
+type HTTPClientConfig struct {
+	// ProxyURL is the proxy to use, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	// environment variables are used when it is empty.
+	ProxyURL string `yaml:"proxy_url,omitempty"`
+	// NoProxy is a comma separated list of hosts and domains not to use ProxyURL for.
+	NoProxy   string    `yaml:"no_proxy,omitempty"`
+	TLSConfig TLSConfig `yaml:"tls_config,omitempty"`
+
+	// Timeout bounds the whole request, including reading the response body.
+	Timeout               model.Duration `yaml:"timeout,omitempty"`
+	DialTimeout           model.Duration `yaml:"dial_timeout,omitempty"`
+	TLSHandshakeTimeout   model.Duration `yaml:"tls_handshake_timeout,omitempty"`
+	ResponseHeaderTimeout model.Duration `yaml:"response_header_timeout,omitempty"`
+	IdleConnTimeout       model.Duration `yaml:"idle_conn_timeout,omitempty"`
+	MaxIdleConns          int            `yaml:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost   int            `yaml:"max_idle_conns_per_host,omitempty"`
+	// EnableHTTP2 defaults to true.
+	EnableHTTP2 *bool `yaml:"enable_http2,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// TLSConfig - TLS configuration of the HTTP client.
+type TLSConfig struct {
+	// CAFile is a CA bundle used instead of the system roots to verify servers.
+	CAFile string `yaml:"ca_file,omitempty"`
+	// CertFile and KeyFile are the client certificate presented to servers.
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *HTTPClientConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain HTTPClientConfig
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "http_client"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *TLSConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain TLSConfig
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "tls_config"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validate checks the HTTP client configuration and fills in the defaults of unset fields.
+func (s *HTTPClientConfig) validate() error {
+	if s.ProxyURL != "" {
+		u, err := url.Parse(s.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("Invalid proxy_url %q: %s", s.ProxyURL, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("Invalid proxy_url %q: unsupported scheme %q", s.ProxyURL, u.Scheme)
+		}
+	}
+	if (s.TLSConfig.CertFile == "") != (s.TLSConfig.KeyFile == "") {
+		return fmt.Errorf("Both cert_file and key_file must be set in tls_config")
+	}
+
+	if s.Timeout == 0 {
+		s.Timeout = DefaultHTTPClientConfig.Timeout
+	}
+	if s.DialTimeout == 0 {
+		s.DialTimeout = DefaultHTTPClientConfig.DialTimeout
+	}
+	if s.TLSHandshakeTimeout == 0 {
+		s.TLSHandshakeTimeout = DefaultHTTPClientConfig.TLSHandshakeTimeout
+	}
+	if s.IdleConnTimeout == 0 {
+		s.IdleConnTimeout = DefaultHTTPClientConfig.IdleConnTimeout
+	}
+	if s.MaxIdleConns == 0 {
+		s.MaxIdleConns = DefaultHTTPClientConfig.MaxIdleConns
+	}
+	if s.MaxIdleConnsPerHost == 0 {
+		s.MaxIdleConnsPerHost = DefaultHTTPClientConfig.MaxIdleConnsPerHost
+	}
+	if s.EnableHTTP2 == nil {
+		enable := true
+		s.EnableHTTP2 = &enable
+	}
+	return nil
+}
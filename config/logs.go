@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// DefaultLogQueryInterval is the interval log queries are run on if none is configured.
+var DefaultLogQueryInterval = model.Duration(5 * time.Minute)
+
+// LogQuery - Log Analytics query run on an interval, exporting a gauge per result row.
+type LogQuery struct {
+	// Name is the name of the exported metric.
+	Name        string `yaml:"name"`
+	WorkspaceID string `yaml:"workspace_id"`
+	Query       string `yaml:"query"`
+	// Timespan limits the time range the query covers, only the time filters of the query apply if unset.
+	Timespan model.Duration `yaml:"timespan,omitempty"`
+	Interval model.Duration `yaml:"interval,omitempty"`
+
+	// ValueColumn is the result column holding the value of the gauge.
+	ValueColumn string `yaml:"value_column"`
+	// LabelColumns are the result columns exported as labels of the same name.
+	LabelColumns []string          `yaml:"label_columns,omitempty"`
+	Labels       map[string]string `yaml:"labels,omitempty"`
+
+	// Credentials is the name of the credentials profile to use, the top level credentials are used if empty.
+	Credentials string `yaml:"credentials,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *LogQuery) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain LogQuery
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "log_queries"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validate checks the log query and fills in defaults.
+func (q *LogQuery) validate(c *Config) error {
+	if !model.IsValidMetricName(model.LabelValue(q.Name)) {
+		return fmt.Errorf("%q is not a valid metric name", q.Name)
+	}
+	if q.WorkspaceID == "" {
+		return fmt.Errorf("workspace_id must be set")
+	}
+	if q.Query == "" {
+		return fmt.Errorf("query must be set")
+	}
+	if err := validateColumns(q.ValueColumn, q.LabelColumns, c.Labels, q.Labels); err != nil {
+		return err
+	}
+	if q.Interval == 0 {
+		q.Interval = DefaultLogQueryInterval
+	}
+	if q.Timespan < 0 {
+		return fmt.Errorf("timespan must not be negative")
+	}
+	if err := validateLabels(q.Labels); err != nil {
+		return err
+	}
+	if _, ok := c.CredentialProfiles[q.Credentials]; q.Credentials != "" && !ok {
+		return fmt.Errorf("Unknown credentials profile %q", q.Credentials)
+	}
+	return nil
+}
+
+// validateColumns checks the result columns mapped to the value and labels of a query. Label
+// columns must not clash with the static labels added to the results.
+func validateColumns(valueColumn string, labelColumns []string, static ...map[string]string) error {
+	if valueColumn == "" {
+		return fmt.Errorf("value_column must be set")
+	}
+	for _, column := range labelColumns {
+		if !model.LabelName(column).IsValid() || column == model.MetricNameLabel {
+			return fmt.Errorf("Label column %q is not a valid label name", column)
+		}
+		for _, labels := range static {
+			if _, ok := labels[column]; ok {
+				return fmt.Errorf("Label column %q clashes with a static label", column)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// OTLPConfig - pushing the collected samples to an OpenTelemetry collector.
+type OTLPConfig struct {
+	// Endpoint is the base URL of the collector, e.g. http://collector:4318. Metrics are
+	// sent to its /v1/metrics path with OTLP/HTTP.
+	Endpoint string `yaml:"endpoint"`
+	// Headers are sent with every request, e.g. for authentication.
+	Headers    map[string]Secret `yaml:"headers,omitempty"`
+	HTTPClient HTTPClientConfig  `yaml:"http_client,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *OTLPConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain OTLPConfig
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "otlp"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface, redacting the credentials of the endpoint.
+func (s OTLPConfig) MarshalYAML() (interface{}, error) {
+	type plain OTLPConfig
+	p := plain(s)
+	p.Endpoint = redactURL(s.Endpoint)
+	return p, nil
+}
+
+// validate checks the OTLP configuration and fills in the defaults of unset fields.
+func (s *OTLPConfig) validate() error {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return fmt.Errorf("Invalid endpoint %q: %s", s.Endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Invalid endpoint %q: must be a http or https URL", s.Endpoint)
+	}
+	s.Endpoint = strings.TrimSuffix(s.Endpoint, "/")
+	return s.HTTPClient.validate()
+}
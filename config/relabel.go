@@ -0,0 +1,145 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/common/model"
+)
+
+// RelabelAction is the action to be performed on relabeling.
+type RelabelAction string
+
+const (
+	// RelabelReplace performs a regex replacement.
+	RelabelReplace RelabelAction = "replace"
+	// RelabelKeep drops metrics for which the regex does not match the source labels.
+	RelabelKeep RelabelAction = "keep"
+	// RelabelDrop drops metrics for which the regex matches the source labels.
+	RelabelDrop RelabelAction = "drop"
+	// RelabelLabelMap copies labels whose name matches the regex to the name given by replacement.
+	RelabelLabelMap RelabelAction = "labelmap"
+	// RelabelLabelDrop removes all labels whose name matches the regex.
+	RelabelLabelDrop RelabelAction = "labeldrop"
+	// RelabelLabelKeep removes all labels whose name does not match the regex.
+	RelabelLabelKeep RelabelAction = "labelkeep"
+)
+
+var relabelTarget = regexp.MustCompile(`^(?:(?:[a-zA-Z_]|\$(?:\{\w+\}|\w+))+\w*)+$`)
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (a *RelabelAction) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	switch act := RelabelAction(s); act {
+	case RelabelReplace, RelabelKeep, RelabelDrop, RelabelLabelMap, RelabelLabelDrop, RelabelLabelKeep:
+		*a = act
+		return nil
+	}
+	return fmt.Errorf("unknown relabel action %q", s)
+}
+
+// Regexp encapsulates a regexp.Regexp and makes it YAML unmarshalable.
+// The expression is anchored at both ends.
+type Regexp struct {
+	*regexp.Regexp
+	original string
+}
+
+// NewRegexp creates a new anchored Regexp and returns an error if the
+// passed-in regular expression does not compile.
+func NewRegexp(s string) (Regexp, error) {
+	regex, err := regexp.Compile("^(?:" + s + ")$")
+	return Regexp{Regexp: regex, original: s}, err
+}
+
+// MustNewRegexp works like NewRegexp, but panics if the regular expression does not compile.
+func MustNewRegexp(s string) Regexp {
+	re, err := NewRegexp(s)
+	if err != nil {
+		panic(err)
+	}
+	return re
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	r, err := NewRegexp(s)
+	if err != nil {
+		return err
+	}
+	*re = r
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (re Regexp) MarshalYAML() (interface{}, error) {
+	if re.Regexp != nil {
+		return re.original, nil
+	}
+	return nil, nil
+}
+
+// DefaultRelabelConfig is the default relabel configuration, matching Prometheus.
+var DefaultRelabelConfig = RelabelConfig{
+	Action:      RelabelReplace,
+	Separator:   ";",
+	Regex:       MustNewRegexp("(.*)"),
+	Replacement: "$1",
+}
+
+// RelabelConfig is the configuration for relabeling of exported metrics.
+// It follows the semantics of the Prometheus metric_relabel_configs.
+type RelabelConfig struct {
+	SourceLabels []string      `yaml:"source_labels,flow,omitempty"`
+	Separator    string        `yaml:"separator,omitempty"`
+	Regex        Regexp        `yaml:"regex,omitempty"`
+	TargetLabel  string        `yaml:"target_label,omitempty"`
+	Replacement  string        `yaml:"replacement,omitempty"`
+	Action       RelabelAction `yaml:"action,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *RelabelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultRelabelConfig
+	type plain RelabelConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if err := checkOverflow(c.XXX, "relabel_config"); err != nil {
+		return err
+	}
+	if c.Regex.Regexp == nil {
+		c.Regex = MustNewRegexp("")
+	}
+	return c.Validate()
+}
+
+// Validate checks that the relabel config is consistent with its action.
+func (c *RelabelConfig) Validate() error {
+	if c.Action == RelabelReplace && !relabelTarget.MatchString(c.TargetLabel) {
+		return fmt.Errorf("%q is invalid 'target_label' for %s action", c.TargetLabel, c.Action)
+	}
+	if c.Action == RelabelLabelMap && !relabelTarget.MatchString(c.Replacement) {
+		return fmt.Errorf("%q is invalid 'replacement' for %s action", c.Replacement, c.Action)
+	}
+	if c.Action == RelabelLabelDrop || c.Action == RelabelLabelKeep {
+		if len(c.SourceLabels) > 0 || c.TargetLabel != "" || c.Replacement != DefaultRelabelConfig.Replacement {
+			return fmt.Errorf("%s action requires only 'regex', and no other fields", c.Action)
+		}
+	}
+	for _, l := range c.SourceLabels {
+		if !model.LabelName(l).IsValid() {
+			return fmt.Errorf("%q is not a valid source label name", l)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+var defaultMaxRetries = 10
+
+// DefaultRemoteWriteConfig holds the defaults of unset remote write settings.
+var DefaultRemoteWriteConfig = RemoteWriteConfig{
+	QueueCapacity:     10000,
+	MaxSamplesPerSend: 500,
+	BatchSendDeadline: model.Duration(5 * time.Second),
+	MaxRetries:        &defaultMaxRetries,
+	MinBackoff:        model.Duration(30 * time.Millisecond),
+	MaxBackoff:        model.Duration(5 * time.Second),
+}
+
+// RemoteWriteConfig - pushing the collected samples to a Prometheus remote write endpoint.
+type RemoteWriteConfig struct {
+	URL string `yaml:"url"`
+	// Headers are sent with every request, e.g. Authorization.
+	Headers    map[string]Secret `yaml:"headers,omitempty"`
+	HTTPClient HTTPClientConfig  `yaml:"http_client,omitempty"`
+
+	// QueueCapacity is the number of samples buffered before new ones are dropped.
+	QueueCapacity     int            `yaml:"queue_capacity,omitempty"`
+	MaxSamplesPerSend int            `yaml:"max_samples_per_send,omitempty"`
+	BatchSendDeadline model.Duration `yaml:"batch_send_deadline,omitempty"`
+	// MaxRetries is the number of times a request failing with a retryable error is retried,
+	// with exponential backoff between MinBackoff and MaxBackoff. 0 disables retries.
+	MaxRetries *int           `yaml:"max_retries,omitempty"`
+	MinBackoff model.Duration `yaml:"min_backoff,omitempty"`
+	MaxBackoff model.Duration `yaml:"max_backoff,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *RemoteWriteConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain RemoteWriteConfig
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if err := checkOverflow(s.XXX, "remote_write"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface, redacting the credentials of the URL.
+func (s RemoteWriteConfig) MarshalYAML() (interface{}, error) {
+	type plain RemoteWriteConfig
+	p := plain(s)
+	p.URL = redactURL(s.URL)
+	return p, nil
+}
+
+// validate checks the remote write configuration and fills in the defaults of unset fields.
+func (s *RemoteWriteConfig) validate() error {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return fmt.Errorf("Invalid url %q: %s", s.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("Invalid url %q: must be a http or https URL", s.URL)
+	}
+	if err := s.HTTPClient.validate(); err != nil {
+		return err
+	}
+
+	if s.QueueCapacity == 0 {
+		s.QueueCapacity = DefaultRemoteWriteConfig.QueueCapacity
+	}
+	if s.MaxSamplesPerSend == 0 {
+		s.MaxSamplesPerSend = DefaultRemoteWriteConfig.MaxSamplesPerSend
+	}
+	if s.BatchSendDeadline == 0 {
+		s.BatchSendDeadline = DefaultRemoteWriteConfig.BatchSendDeadline
+	}
+	if s.MaxRetries == nil {
+		maxRetries := *DefaultRemoteWriteConfig.MaxRetries
+		s.MaxRetries = &maxRetries
+	}
+	if s.MinBackoff == 0 {
+		s.MinBackoff = DefaultRemoteWriteConfig.MinBackoff
+	}
+	if s.MaxBackoff == 0 {
+		s.MaxBackoff = DefaultRemoteWriteConfig.MaxBackoff
+	}
+	if s.QueueCapacity < 0 || s.MaxSamplesPerSend < 0 || *s.MaxRetries < 0 {
+		return fmt.Errorf("queue_capacity, max_samples_per_send and max_retries must not be negative")
+	}
+	if s.MinBackoff > s.MaxBackoff {
+		return fmt.Errorf("min_backoff must not be greater than max_backoff")
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const redacted = "<secret>"
+
+// Secret special type for storing secrets, redacted when marshalled or printed.
+type Secret string
+
+// MarshalYAML implements the yaml.Marshaler interface for Secrets.
+func (s Secret) MarshalYAML() (interface{}, error) {
+	if s != "" {
+		return redacted, nil
+	}
+	return nil, nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface for Secrets.
+func (s *Secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Secret
+	return unmarshal((*plain)(s))
+}
+
+// String implements the fmt.Stringer interface, so Secrets aren't leaked in logs.
+func (s Secret) String() string {
+	if s != "" {
+		return redacted
+	}
+	return ""
+}
+
+// GoString implements the fmt.GoStringer interface.
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// redactURL strips the user info from the URL, as it may hold credentials.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return redacted
+	}
+	u.User = nil
+	return u.String()
+}
+
+var envReference = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// expandEnv replaces ${VAR} references with the value of the environment variable.
+// Unlike os.ExpandEnv, a $ not followed by a braced name is kept as is.
+func expandEnv(s string) (string, error) {
+	var err error
+	expanded := envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value
+	})
+	return expanded, err
+}
+
+// expandEnv expands environment variable references in the credentials.
+func (s *Credentials) expandEnv() error {
+	for name, field := range map[string]*string{
+		"subscription_id":    &s.SubscriptionID,
+		"client_id":          &s.ClientID,
+		"client_secret":      (*string)(&s.ClientSecret),
+		"client_secret_file": &s.ClientSecretFile,
+		"tenant_id":          &s.TenantID,
+	} {
+		expanded, err := expandEnv(*field)
+		if err != nil {
+			return fmt.Errorf("Error expanding %s: %s", name, err)
+		}
+		*field = expanded
+	}
+	return nil
+}
+
+// GetClientSecret returns the client secret, reading it from ClientSecretFile if set.
+// The file is read on every call, so secret rotations are picked up on the next token refresh.
+func (s *Credentials) GetClientSecret() (string, error) {
+	if s.ClientSecretFile == "" {
+		return string(s.ClientSecret), nil
+	}
+	secret, err := ioutil.ReadFile(s.ClientSecretFile)
+	if err != nil {
+		return "", fmt.Errorf("Error reading client secret file: %s", err)
+	}
+	return strings.TrimSpace(string(secret)), nil
+}
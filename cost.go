@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/prometheus/common/model"
+)
+
+var invalidLabelChars = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// AzureCostQueryResponse represents a page of Cost Management query results.
+type AzureCostQueryResponse struct {
+	Properties struct {
+		NextLink string `json:"nextLink"`
+		Columns  []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"columns"`
+		Rows [][]interface{} `json:"rows"`
+	} `json:"properties"`
+	APIError struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// costTimeframe is a time range costs are exported for.
+type costTimeframe struct {
+	metric string
+	// body returns the timeframe part of the query body at the given time.
+	body func(now time.Time) map[string]interface{}
+}
+
+var costTimeframes = []costTimeframe{
+	{
+		metric: "azure_cost_month_to_date",
+		body: func(now time.Time) map[string]interface{} {
+			return map[string]interface{}{"timeframe": "MonthToDate"}
+		},
+	},
+	{
+		// The previous day, as the cost of the current one is far from complete.
+		metric: "azure_cost_daily",
+		body: func(now time.Time) map[string]interface{} {
+			day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+			return map[string]interface{}{
+				"timeframe": "Custom",
+				"timePeriod": map[string]string{
+					"from": day.Format(time.RFC3339),
+					"to":   day.Add(24*time.Hour - time.Second).Format(time.RFC3339),
+				},
+			}
+		},
+	},
+}
+
+// newCostQuery returns a background query fetching the costs of the configured scopes.
+func newCostQuery(c *config.Config) *backgroundQuery {
+	return &backgroundQuery{
+		name:     "Cost Management query",
+		interval: time.Duration(c.Cost.Interval),
+		run: func() ([]sample, error) {
+			return ac.costSamples(c, time.Now().UTC())
+		},
+	}
+}
+
+// costSamples queries the month to date and daily costs of every configured scope.
+func (ac *AzureClient) costSamples(c *config.Config, now time.Time) ([]sample, error) {
+	scopes := c.Cost.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"/subscriptions/" + c.ProfileCredentials(c.Cost.Credentials).SubscriptionID}
+	}
+
+	samples := []sample{}
+	for _, scope := range scopes {
+		for _, tf := range costTimeframes {
+			data, err := ac.queryCost(c, scope, tf.body(now))
+			if err != nil {
+				return nil, fmt.Errorf("scope %s: %v", scope, err)
+			}
+			s, err := costQuerySamples(c, scope, tf.metric, data)
+			if err != nil {
+				return nil, fmt.Errorf("scope %s: %v", scope, err)
+			}
+			samples = append(samples, s...)
+		}
+	}
+	return samples, nil
+}
+
+// queryCost runs a cost query for the scope and returns the rows of all result pages.
+func (ac *AzureClient) queryCost(c *config.Config, scope string, timeframe map[string]interface{}) (AzureCostQueryResponse, error) {
+	apiVersion := "2021-10-01"
+	token, err := ac.getResourceAccessToken(c, c.Cost.Credentials, c.Endpoints.ResourceManager)
+	if err != nil {
+		return AzureCostQueryResponse{}, fmt.Errorf("Error refreshing access token: %v", err)
+	}
+
+	grouping := []map[string]string{}
+	for _, g := range c.Cost.GroupBy {
+		if strings.HasPrefix(g, config.CostTagPrefix) {
+			grouping = append(grouping, map[string]string{"type": "TagKey", "name": strings.TrimPrefix(g, config.CostTagPrefix)})
+		} else {
+			grouping = append(grouping, map[string]string{"type": "Dimension", "name": g})
+		}
+	}
+	query := map[string]interface{}{
+		"type": c.Cost.Type,
+		"dataset": map[string]interface{}{
+			"granularity": "None",
+			"aggregation": map[string]interface{}{
+				"totalCost": map[string]string{"name": "Cost", "function": "Sum"},
+			},
+			"grouping": grouping,
+		},
+	}
+	for k, v := range timeframe {
+		query[k] = v
+	}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return AzureCostQueryResponse{}, fmt.Errorf("Error marshalling query: %v", err)
+	}
+
+	result := AzureCostQueryResponse{}
+	target := fmt.Sprintf("%s%s/providers/Microsoft.CostManagement/query?api-version=%s", c.Endpoints.ResourceManager, scope, apiVersion)
+	for target != "" {
+		page, err := ac.queryCostPage(target, token, body)
+		if err != nil {
+			return AzureCostQueryResponse{}, err
+		}
+		result.Properties.Columns = page.Properties.Columns
+		result.Properties.Rows = append(result.Properties.Rows, page.Properties.Rows...)
+		target = page.Properties.NextLink
+	}
+	return result, nil
+}
+
+func (ac *AzureClient) queryCostPage(target, token string, query []byte) (AzureCostQueryResponse, error) {
+	req, err := http.NewRequest("POST", target, bytes.NewReader(query))
+	if err != nil {
+		return AzureCostQueryResponse{}, fmt.Errorf("Error creating HTTP request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ac.httpClient().Do(req)
+	if err != nil {
+		return AzureCostQueryResponse{}, fmt.Errorf("Error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return AzureCostQueryResponse{}, fmt.Errorf("Error reading body of response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return AzureCostQueryResponse{}, newAzureError(resp, body)
+	}
+
+	var data AzureCostQueryResponse
+	if err := json.Unmarshal(body, &data); err != nil {
+		return AzureCostQueryResponse{}, fmt.Errorf("Error unmarshalling response body: %v", err)
+	}
+	if data.APIError.Code != "" {
+		return AzureCostQueryResponse{}, fmt.Errorf("Cost Management API returned error: %s - %v", data.APIError.Code, data.APIError.Message)
+	}
+	return data, nil
+}
+
+// costQuerySamples converts the result rows of a cost query to samples of the given metric.
+// The costs of rows with the same label values, e.g. of tag keys differing in case, are summed up.
+func costQuerySamples(c *config.Config, scope, metric string, data AzureCostQueryResponse) ([]sample, error) {
+	columns := make(map[string]int, len(data.Properties.Columns))
+	for i, column := range data.Properties.Columns {
+		columns[column.Name] = i
+	}
+	costIndex, ok := columns["Cost"]
+	if !ok {
+		return nil, fmt.Errorf("Cost column not found in query result")
+	}
+
+	samples := []sample{}
+	// Index of the sample of every label set.
+	index := make(map[uint64]int)
+	for _, row := range data.Properties.Rows {
+		if len(row) != len(data.Properties.Columns) {
+			return nil, fmt.Errorf("Query result row has %d columns, expected %d", len(row), len(data.Properties.Columns))
+		}
+		value, ok := queryValue(row[costIndex])
+		if !ok {
+			continue
+		}
+		labels := map[string]string{"scope": scope}
+		if i, ok := columns["Currency"]; ok {
+			labels["currency"] = queryLabelValue(row[i])
+		}
+		for _, g := range c.Cost.GroupBy {
+			if strings.HasPrefix(g, config.CostTagPrefix) {
+				name := "tag_" + invalidLabelChars.ReplaceAllString(strings.TrimPrefix(g, config.CostTagPrefix), "_")
+				labels[name] = ""
+				if i, ok := columns["TagValue"]; ok {
+					labels[name] = queryLabelValue(row[i])
+				}
+				continue
+			}
+			i, ok := columns[g]
+			if !ok {
+				return nil, fmt.Errorf("Group column %q not found in query result", g)
+			}
+			labels[config.DimensionLabelName(g)] = queryLabelValue(row[i])
+		}
+		labels = AddStaticLabels(labels, c.Labels)
+		key := model.LabelsToSignature(labels)
+		if i, ok := index[key]; ok {
+			samples[i].value += value
+			continue
+		}
+		index[key] = len(samples)
+		samples = append(samples, sample{name: metric, labels: labels, value: value})
+	}
+	return samples, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/azuretest"
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+)
+
+func TestCollectCosts(t *testing.T) {
+	columns := []azuretest.Column{
+		{Name: "Cost", Type: "Number"},
+		{Name: "ResourceGroupName", Type: "String"},
+		{Name: "TagKey", Type: "String"},
+		{Name: "TagValue", Type: "String"},
+		{Name: "Currency", Type: "String"},
+	}
+	srv := setup(t, func(srv *azuretest.Server, c *config.Config) {
+		srv.SetCosts("MonthToDate", azuretest.Table{
+			Columns: columns,
+			Rows: [][]interface{}{
+				{120.5, "blog-group", "env", "production", "EUR"},
+				{30.25, "vm-group", "env", "test", "EUR"},
+				{4, "vm-group", "", "", "EUR"},
+				{0.75, "vm-group", "Env", "test", "EUR"},
+			},
+		}, 2)
+		srv.SetCosts("Custom", azuretest.Table{
+			Columns: columns,
+			Rows: [][]interface{}{
+				{10, "blog-group", "env", "production", "EUR"},
+			},
+		}, 2)
+		c.Cost = config.CostConfig{
+			Enabled: true,
+			GroupBy: []string{"ResourceGroupName", "tag:env"},
+		}
+	})
+	defer srv.Close()
+
+	runBackgroundQueries()
+	scope := "/subscriptions/" + azuretest.SubscriptionID
+	assertSamples(t, scrape(t),
+		`azure_cost_daily{currency="EUR",resource_group_name="blog-group",scope="`+scope+`",tag_env="production"} 10`,
+		`azure_cost_month_to_date{currency="EUR",resource_group_name="blog-group",scope="`+scope+`",tag_env="production"} 120.5`,
+		`azure_cost_month_to_date{currency="EUR",resource_group_name="vm-group",scope="`+scope+`",tag_env="test"} 31`,
+		`azure_cost_month_to_date{currency="EUR",resource_group_name="vm-group",scope="`+scope+`",tag_env=""} 4`,
+	)
+	if reqs := srv.Requests(); len(reqs) != 3 {
+		t.Errorf("Expected two pages of month to date and one of daily costs, got %d requests", len(reqs))
+	}
+
+	now := time.Date(2020, 3, 1, 8, 30, 0, 0, time.UTC)
+	if _, err := ac.costSamples(sc.C, now); err != nil {
+		t.Fatal(err)
+	}
+	reqs := srv.Requests()
+	var body struct {
+		Type       string            `json:"type"`
+		Timeframe  string            `json:"timeframe"`
+		TimePeriod map[string]string `json:"timePeriod"`
+	}
+	if err := json.Unmarshal([]byte(reqs[len(reqs)-1].Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Type != config.CostTypeActual || body.Timeframe != "Custom" ||
+		body.TimePeriod["from"] != "2020-02-29T00:00:00Z" || body.TimePeriod["to"] != "2020-02-29T23:59:59Z" {
+		t.Errorf("Unexpected daily cost query %s", reqs[len(reqs)-1].Body)
+	}
+}
+
+func TestCostQueryErrors(t *testing.T) {
+	srv := setup(t, func(_ *azuretest.Server, c *config.Config) {
+		c.Cost = config.CostConfig{Enabled: true}
+	})
+	defer srv.Close()
+
+	srv.FailNext(1, 502, "BadGateway", "Bad gateway")
+	_, err := ac.queryCost(sc.C, "/subscriptions/"+azuretest.SubscriptionID, costTimeframes[0].body(time.Now()))
+	if e, ok := err.(*azureError); !ok || e.status != 502 || e.code != "BadGateway" {
+		t.Errorf("Expected an Azure error with status 502, got %v", err)
+	}
+}
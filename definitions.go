@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/go-kit/kit/log/level"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// resourceDefinitions holds the metric definitions of a single resource and metric namespace.
+type resourceDefinitions struct {
+	Resource  string                     `json:"resource" yaml:"resource"`
+	Namespace string                     `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Metrics   []metricDefinitionResponse `json:"metrics" yaml:"metrics"`
+
+	// target is the configured target the definitions were fetched for.
+	target config.Target
+}
+
+// PrintDefinitions - Prints the metric definitions of all resources in the given format.
+func PrintDefinitions(w io.Writer, defs []resourceDefinitions, format string) error {
+	switch format {
+	case "json":
+		out, err := json.MarshalIndent(defs, "", "  ")
+		if err != nil {
+			return fmt.Errorf("Error marshalling definitions: %v", err)
+		}
+		_, err = fmt.Fprintln(w, string(out))
+		return err
+	case "yaml":
+		out, err := yaml.Marshal(defs)
+		if err != nil {
+			return fmt.Errorf("Error marshalling definitions: %v", err)
+		}
+		_, err = w.Write(out)
+		return err
+	}
+
+	for _, d := range defs {
+		fmt.Fprintf(w, "Resource: %s\n", d.Resource)
+		if d.Namespace != "" {
+			fmt.Fprintf(w, "Metric namespace: %s\n", d.Namespace)
+		}
+		fmt.Fprintf(w, "\nAvailable Metrics:\n")
+		for _, r := range d.Metrics {
+			dimensions := []string{}
+			for _, dim := range r.Dimensions {
+				dimensions = append(dimensions, dim.Value)
+			}
+			timeGrains := []string{}
+			for _, a := range r.MetricAvailabilities {
+				timeGrains = append(timeGrains, a.TimeGrain)
+			}
+			fmt.Fprintf(w, "- %s\n", r.Name.Value)
+			fmt.Fprintf(w, "    unit: %s, primary aggregation: %s, supported aggregations: %s\n",
+				r.Unit, r.PrimaryAggregationType, strings.Join(r.SupportedAggregationTypes, ","))
+			fmt.Fprintf(w, "    time grains: %s\n", strings.Join(timeGrains, ","))
+			if len(dimensions) > 0 {
+				fmt.Fprintf(w, "    dimensions: %s (required: %t)\n", strings.Join(dimensions, ","), r.IsDimensionRequired)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// PrintNamespaces - Prints the metric namespaces available for each resource in the given format.
+func PrintNamespaces(w io.Writer, results map[string]AzureMetricNamespaceResponse, format string) error {
+	resources := make([]string, 0, len(results))
+	for k := range results {
+		resources = append(resources, k)
+	}
+	sort.Strings(resources)
+
+	switch format {
+	case "json", "yaml":
+		type resourceNamespaces struct {
+			Resource   string   `json:"resource" yaml:"resource"`
+			Namespaces []string `json:"namespaces" yaml:"namespaces"`
+		}
+		out := []resourceNamespaces{}
+		for _, r := range resources {
+			rn := resourceNamespaces{Resource: r, Namespaces: []string{}}
+			for _, ns := range results[r].MetricNamespaces {
+				rn.Namespaces = append(rn.Namespaces, ns.Properties.MetricNamespaceName)
+			}
+			out = append(out, rn)
+		}
+		var b []byte
+		var err error
+		if format == "json" {
+			b, err = json.MarshalIndent(out, "", "  ")
+			b = append(b, '\n')
+		} else {
+			b, err = yaml.Marshal(out)
+		}
+		if err != nil {
+			return fmt.Errorf("Error marshalling namespaces: %v", err)
+		}
+		_, err = w.Write(b)
+		return err
+	}
+
+	for _, r := range resources {
+		fmt.Fprintf(w, "Resource: %s\n\nAvailable Metric Namespaces:\n", r)
+		for _, ns := range results[r].MetricNamespaces {
+			fmt.Fprintf(w, "- %s (%s)\n", ns.Properties.MetricNamespaceName, ns.Classification)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// GenerateTargets - Builds target configurations querying every available metric of each resource.
+// As aggregations are set per target, metrics are grouped in one target per primary aggregation.
+// Generated targets keep the credentials profile and static labels of the configured target.
+func GenerateTargets(defs []resourceDefinitions) []config.Target {
+	targets := []config.Target{}
+	for _, d := range defs {
+		byAggregation := make(map[string][]config.Metric)
+		for _, r := range d.Metrics {
+			if r.IsDimensionRequired {
+				level.Info(logger).Log("msg", "Skipping metric, it requires a dimension", "target", d.Resource, "metric", r.Name.Value)
+				continue
+			}
+			aggr := primaryAggregation(r)
+			if aggr == "" {
+				level.Info(logger).Log("msg", "Skipping metric, no supported aggregation", "target", d.Resource, "metric", r.Name.Value)
+				continue
+			}
+			byAggregation[aggr] = append(byAggregation[aggr], config.Metric{Name: r.Name.Value})
+		}
+
+		for _, aggr := range config.ValidAggregations {
+			metrics, ok := byAggregation[aggr]
+			if !ok {
+				continue
+			}
+			sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+			targets = append(targets, config.Target{
+				Resource:        d.Resource,
+				Metrics:         metrics,
+				Aggregations:    []string{aggr},
+				MetricNamespace: d.Namespace,
+				Labels:          d.target.Labels,
+				Credentials:     d.target.Credentials,
+			})
+		}
+	}
+	return targets
+}
+
+// primaryAggregation returns the primary aggregation of the metric if the exporter supports it,
+// or else the first supported aggregation the exporter can query.
+func primaryAggregation(def metricDefinitionResponse) string {
+	candidates := append([]string{def.PrimaryAggregationType}, def.SupportedAggregationTypes...)
+	for _, c := range candidates {
+		for _, valid := range config.ValidAggregations {
+			if strings.EqualFold(c, valid) {
+				return valid
+			}
+		}
+	}
+	return ""
+}
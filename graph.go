@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// resourceGraphPageSize is the number of rows requested per page of Resource Graph results.
+	resourceGraphPageSize = 1000
+	// maxResourceGraphPages caps the pages fetched for a query, in case Azure keeps returning
+	// new $skipTokens.
+	maxResourceGraphPages = 1000
+)
+
+// ResourceGraphResponse represents a page of Azure Resource Graph query results.
+type ResourceGraphResponse struct {
+	TotalRecords int64                    `json:"totalRecords"`
+	Count        int64                    `json:"count"`
+	Data         []map[string]interface{} `json:"data"`
+	SkipToken    string                   `json:"$skipToken"`
+	APIError     struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// newResourceGraphQuery returns a background query running the given Resource Graph query.
+func newResourceGraphQuery(c *config.Config, q *config.ResourceGraphQuery) *backgroundQuery {
+	return &backgroundQuery{
+		name:     fmt.Sprintf("Resource Graph query %s", q.Name),
+		interval: time.Duration(q.Interval),
+		run: func() ([]sample, error) {
+			rows, err := ac.queryResourceGraph(c, q)
+			if err != nil {
+				return nil, err
+			}
+			return resourceGraphSamples(c, q, rows)
+		},
+	}
+}
+
+// queryResourceGraph runs the Resource Graph query and returns the rows of all result pages. It
+// fails if a page points to one already fetched, or if the query has too many pages.
+func (ac *AzureClient) queryResourceGraph(c *config.Config, q *config.ResourceGraphQuery) ([]map[string]interface{}, error) {
+	token, err := ac.getResourceAccessToken(c, q.Credentials, c.Endpoints.ResourceManager)
+	if err != nil {
+		return nil, fmt.Errorf("Error refreshing access token: %v", err)
+	}
+	subscriptions := q.Subscriptions
+	if len(subscriptions) == 0 {
+		subscriptions = []string{c.ProfileCredentials(q.Credentials).SubscriptionID}
+	}
+
+	rows := []map[string]interface{}{}
+	skipToken := ""
+	seen := map[string]bool{}
+	for pages := 1; ; pages++ {
+		page, err := ac.queryResourceGraphPage(c, token, subscriptions, q.Query, skipToken)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, page.Data...)
+		if page.SkipToken == "" {
+			return rows, nil
+		}
+		if seen[page.SkipToken] {
+			return nil, fmt.Errorf("Query result pages repeat the $skipToken %q", page.SkipToken)
+		}
+		if pages >= maxResourceGraphPages {
+			return nil, fmt.Errorf("Query result has more than %d pages", maxResourceGraphPages)
+		}
+		seen[page.SkipToken] = true
+		skipToken = page.SkipToken
+	}
+}
+
+func (ac *AzureClient) queryResourceGraphPage(c *config.Config, token string, subscriptions []string, query, skipToken string) (ResourceGraphResponse, error) {
+	apiVersion := "2021-03-01"
+	options := map[string]interface{}{
+		"resultFormat": "objectArray",
+		"$top":         resourceGraphPageSize,
+	}
+	if skipToken != "" {
+		options["$skipToken"] = skipToken
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"subscriptions": subscriptions,
+		"query":         query,
+		"options":       options,
+	})
+	if err != nil {
+		return ResourceGraphResponse{}, fmt.Errorf("Error marshalling query: %v", err)
+	}
+
+	target := fmt.Sprintf("%s/providers/Microsoft.ResourceGraph/resources?api-version=%s", c.Endpoints.ResourceManager, apiVersion)
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return ResourceGraphResponse{}, fmt.Errorf("Error creating HTTP request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ac.httpClient().Do(req)
+	if err != nil {
+		return ResourceGraphResponse{}, fmt.Errorf("Error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return ResourceGraphResponse{}, fmt.Errorf("Error reading body of response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return ResourceGraphResponse{}, newAzureError(resp, body)
+	}
+
+	var data ResourceGraphResponse
+	if err := json.Unmarshal(body, &data); err != nil {
+		return ResourceGraphResponse{}, fmt.Errorf("Error unmarshalling response body: %v", err)
+	}
+	if data.APIError.Code != "" {
+		return ResourceGraphResponse{}, fmt.Errorf("Resource Graph API returned error: %s - %v", data.APIError.Code, data.APIError.Message)
+	}
+	return data, nil
+}
+
+// resourceGraphSamples converts the result rows to samples. Rows without a numeric value are skipped.
+// Rows with the same label values would be exported as the same series, so only the first one is kept.
+func resourceGraphSamples(c *config.Config, q *config.ResourceGraphQuery, rows []map[string]interface{}) ([]sample, error) {
+	samples := []sample{}
+	seen := make(map[uint64]bool)
+	for _, row := range rows {
+		v, ok := row[q.ValueColumn]
+		if !ok {
+			return nil, fmt.Errorf("Value column %q not found in query result", q.ValueColumn)
+		}
+		value, ok := queryValue(v)
+		if !ok {
+			continue
+		}
+		labels := make(map[string]string, len(q.LabelColumns))
+		for _, column := range q.LabelColumns {
+			v, ok := row[column]
+			if !ok {
+				return nil, fmt.Errorf("Label column %q not found in query result", column)
+			}
+			labels[column] = queryLabelValue(v)
+		}
+		labels = AddStaticLabels(labels, c.Labels, q.Labels)
+		key := model.LabelsToSignature(labels)
+		if seen[key] {
+			level.Warn(logger).Log("msg", "Skipping query result row, another row has the same labels", "query", q.Name, "labels", fmt.Sprint(labels))
+			continue
+		}
+		seen[key] = true
+		samples = append(samples, sample{name: q.Name, labels: labels, value: value})
+	}
+	return samples, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/RobustPerception/azure_metrics_exporter/azuretest"
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+)
+
+func TestCollectResourceGraphQueries(t *testing.T) {
+	srv := setup(t, func(srv *azuretest.Server, c *config.Config) {
+		srv.SetResourceGraphRows([]map[string]interface{}{
+			{"sku": "Standard_D2s_v3", "location": "westeurope", "count_": 4},
+			{"sku": "Standard_B1s", "location": "westeurope", "count_": 1},
+			{"sku": "Standard_B1s", "location": "northeurope", "count_": 2},
+			{"sku": "Standard_E4s_v3", "location": "northeurope", "count_": nil},
+		}, 2)
+		c.ResourceGraphQueries = []*config.ResourceGraphQuery{{
+			Name:          "azure_vm_count",
+			Query:         "Resources | where type =~ 'microsoft.compute/virtualmachines' | summarize count() by sku = tostring(properties.hardwareProfile.vmSize), location",
+			Subscriptions: []string{azuretest.SubscriptionID, "other-subscription"},
+			ValueColumn:   "count_",
+			LabelColumns:  []string{"sku", "location"},
+		}}
+	})
+	defer srv.Close()
+
+	runBackgroundQueries()
+	assertSamples(t, scrape(t),
+		`azure_vm_count{location="northeurope",sku="Standard_B1s"} 2`,
+		`azure_vm_count{location="westeurope",sku="Standard_B1s"} 1`,
+		`azure_vm_count{location="westeurope",sku="Standard_D2s_v3"} 4`,
+	)
+
+	reqs := srv.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("Expected two pages to be requested, got %d requests", len(reqs))
+	}
+	var body struct {
+		Subscriptions []string `json:"subscriptions"`
+		Options       struct {
+			SkipToken string `json:"$skipToken"`
+		} `json:"options"`
+	}
+	if err := json.Unmarshal([]byte(reqs[1].Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Subscriptions) != 2 || body.Options.SkipToken == "" {
+		t.Errorf("Unexpected request for the second page: %s", reqs[1].Body)
+	}
+}
+
+func TestResourceGraphQueryErrors(t *testing.T) {
+	srv := setup(t, func(srv *azuretest.Server, c *config.Config) {
+		srv.SetResourceGraphRows([]map[string]interface{}{
+			{"location": "westeurope", "count_": 4},
+			{"location": "westeurope", "count_": 1},
+		}, 10)
+		c.ResourceGraphQueries = []*config.ResourceGraphQuery{{
+			Name:         "azure_vm_count",
+			Query:        "Resources | summarize count() by location, sku",
+			ValueColumn:  "count_",
+			LabelColumns: []string{"location"},
+		}}
+	})
+	defer srv.Close()
+	q := sc.C.ResourceGraphQueries[0]
+
+	srv.FailNext(1, 502, "BadGateway", "Bad gateway")
+	_, err := ac.queryResourceGraph(sc.C, q)
+	if e, ok := err.(*azureError); !ok || e.status != 502 || e.code != "BadGateway" {
+		t.Errorf("Expected an Azure error with status 502, got %v", err)
+	}
+
+	rows, err := ac.queryResourceGraph(sc.C, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rows with the same labels as a previous one are skipped.
+	samples, err := resourceGraphSamples(sc.C, q, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 1 || samples[0].value != 4 {
+		t.Errorf("Expected the first row of the label values, got %v", samples)
+	}
+
+	srv.SetResourceGraphRows([]map[string]interface{}{{"count_": 1}, {"count_": 2}, {"count_": 3}}, 1)
+	srv.SetResourceGraphSkipToken("1")
+	if _, err := ac.queryResourceGraph(sc.C, q); err == nil {
+		t.Errorf("Expected an error for a repeated $skipToken")
+	}
+}
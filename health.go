@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+)
+
+// AzureAvailabilityStatusResponse represents the current Resource Health availability status of a resource.
+type AzureAvailabilityStatusResponse struct {
+	ID         string `json:"id"`
+	Properties struct {
+		AvailabilityState string `json:"availabilityState"`
+		Summary           string `json:"summary"`
+		ReasonType        string `json:"reasonType"`
+		ReasonChronicity  string `json:"reasonChronicity"`
+	} `json:"properties"`
+	APIError struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// newResourceHealthQuery returns a background query fetching the availability status of all targets.
+func newResourceHealthQuery(c *config.Config) *backgroundQuery {
+	return &backgroundQuery{
+		name:     "Resource Health query",
+		interval: time.Duration(c.ResourceHealth.Interval),
+		run: func() ([]sample, error) {
+			return ac.resourceHealthSamples(c)
+		},
+	}
+}
+
+// resourceHealthSamples returns the availability status of every target resource. Targets whose
+// status can't be fetched are logged and skipped, so one failing resource doesn't hide the others.
+func (ac *AzureClient) resourceHealthSamples(c *config.Config) ([]sample, error) {
+	samples := []sample{}
+	// Resource IDs are case insensitive.
+	seen := make(map[string]bool)
+	// Resources in the same resource group can have the same name, their statuses would be
+	// exported as the same series.
+	seenLabels := make(map[uint64]string)
+	for _, target := range c.Targets {
+		if seen[strings.ToLower(target.Resource)] {
+			continue
+		}
+		seen[strings.ToLower(target.Resource)] = true
+
+		status, err := ac.getAvailabilityStatus(c, target)
+		if err != nil {
+			level.Error(logger).Log(append([]interface{}{"msg", "Failed to get availability status", "target", target.Resource}, errorFields(err)...)...)
+			continue
+		}
+		resourceID := fmt.Sprintf("/subscriptions/%s%s", c.TargetCredentials(target).SubscriptionID, target.Resource)
+		labels := AddStaticLabels(CreateResourceLabels(resourceID), TargetStaticLabels(c, target))
+		key := model.LabelsToSignature(labels)
+		if other, ok := seenLabels[key]; ok {
+			level.Warn(logger).Log("msg", "Skipping availability status, another resource has the same labels", "target", target.Resource, "other", other)
+			continue
+		}
+		seenLabels[key] = target.Resource
+		labels["status"] = status.Properties.AvailabilityState
+		labels["reason_type"] = status.Properties.ReasonType
+		labels["reason_chronicity"] = status.Properties.ReasonChronicity
+		samples = append(samples, sample{
+			target: target,
+			name:   "azure_resource_health_status",
+			labels: labels,
+			value:  1,
+		})
+	}
+	return samples, nil
+}
+
+// getAvailabilityStatus gets the current Resource Health availability status of the target resource.
+func (ac *AzureClient) getAvailabilityStatus(c *config.Config, target config.Target) (AzureAvailabilityStatusResponse, error) {
+	apiVersion := "2020-05-01"
+	token, err := ac.getResourceAccessToken(c, target.Credentials, c.Endpoints.ResourceManager)
+	if err != nil {
+		return AzureAvailabilityStatusResponse{}, fmt.Errorf("Error refreshing access token: %v", err)
+	}
+
+	resource := fmt.Sprintf("subscriptions/%s%s", c.TargetCredentials(target).SubscriptionID, target.Resource)
+	statusTarget := fmt.Sprintf("%s/%s/providers/Microsoft.ResourceHealth/availabilityStatuses/current?api-version=%s", c.Endpoints.ResourceManager, resource, apiVersion)
+	req, err := http.NewRequest("GET", statusTarget, nil)
+	if err != nil {
+		return AzureAvailabilityStatusResponse{}, fmt.Errorf("Error creating HTTP request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := ac.httpClient().Do(req)
+	if err != nil {
+		return AzureAvailabilityStatusResponse{}, fmt.Errorf("Error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return AzureAvailabilityStatusResponse{}, fmt.Errorf("Error reading body of response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return AzureAvailabilityStatusResponse{}, newAzureError(resp, body)
+	}
+
+	var data AzureAvailabilityStatusResponse
+	if err := json.Unmarshal(body, &data); err != nil {
+		return AzureAvailabilityStatusResponse{}, fmt.Errorf("Error unmarshalling response body: %v", err)
+	}
+	if data.APIError.Code != "" {
+		return AzureAvailabilityStatusResponse{}, fmt.Errorf("Resource Health API returned error: %s - %v", data.APIError.Code, data.APIError.Message)
+	}
+	return data, nil
+}
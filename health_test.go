@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/RobustPerception/azure_metrics_exporter/azuretest"
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+)
+
+func TestCollectResourceHealth(t *testing.T) {
+	vm := config.Target{Resource: azuretest.VMResource}
+	missing := config.Target{Resource: "/resourceGroups/gone/providers/Microsoft.Web/sites/gone"}
+	lower := config.Target{Resource: strings.ToLower(azuretest.WebAppResource)}
+	// A resource with the name of the web app in the same group.
+	sameName := config.Target{Resource: "/resourceGroups/blog-group/providers/Microsoft.Insights/components/blog"}
+	short := config.Target{Resource: "/resourceGroups/short"}
+	srv := setup(t, func(srv *azuretest.Server, c *config.Config) {
+		srv.AddResource(azuretest.Resource{ID: sameName.Resource, Type: "Microsoft.Insights/components"})
+		srv.AddResource(azuretest.Resource{ID: short.Resource, Type: "Microsoft.Resources/resourceGroups"})
+		res := azuretest.VM()
+		res.Health = azuretest.Health{
+			AvailabilityState: "Unavailable",
+			Summary:           "We're sorry, your virtual machine isn't available because of connectivity loss.",
+			ReasonType:        "Unplanned",
+			ReasonChronicity:  "Transient",
+		}
+		srv.AddResource(res)
+		c.ResourceHealth.Enabled = true
+		c.Labels = map[string]string{"env": "production"}
+	}, webAppTarget(), vm, webAppTarget(), missing, lower, sameName, short)
+	defer srv.Close()
+
+	// Only run the background queries, the targets have no metrics to collect.
+	runBackgroundQueries()
+	assertSamples(t, scrape(t),
+		`azure_resource_health_status{env="production",reason_chronicity="",reason_type="",resource_group="blog-group",resource_name="blog",status="Available"} 1`,
+		`azure_resource_health_status{env="production",reason_chronicity="Transient",reason_type="Unplanned",resource_group="vm-group",resource_name="vm1",status="Unavailable"} 1`,
+		`azure_resource_health_status{env="production",reason_chronicity="",reason_type="",resource_group="short",resource_name="",status="Available"} 1`,
+	)
+	if reqs := srv.Requests(); len(reqs) != 5 {
+		t.Errorf("Expected one availability status request per distinct resource, got %d", len(reqs))
+	}
+
+	srv.FailNext(1, 502, "BadGateway", "Bad gateway")
+	_, err := ac.getAvailabilityStatus(sc.C, vm)
+	if e, ok := err.(*azureError); !ok || e.status != 502 || e.code != "BadGateway" {
+		t.Errorf("Expected an Azure error with status 502, got %v", err)
+	}
+}
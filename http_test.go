@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+)
+
+func TestMatchNoProxy(t *testing.T) {
+	noProxy := splitNoProxy("localhost, .example.com,10.0.0.0/8")
+	for host, want := range map[string]bool{
+		"localhost":               true,
+		"example.com":             true,
+		"api.example.com":         true,
+		"notexample.com":          false,
+		"10.1.2.3":                true,
+		"192.168.0.1":             false,
+		"management.azure.com":    false,
+		"LOGIN.EXAMPLE.COM":       true,
+		"login.microsoftonline.c": false,
+	} {
+		if got := matchNoProxy(noProxy, host); got != want {
+			t.Errorf("matchNoProxy(%q) = %t, want %t", host, got, want)
+		}
+	}
+	if !matchNoProxy(splitNoProxy("*"), "anything") {
+		t.Errorf("Expected * to match all hosts")
+	}
+}
+
+func TestHTTPClientProxy(t *testing.T) {
+	proxied := []string{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+	}))
+	defer proxy.Close()
+
+	cfg := config.HTTPClientConfig{ProxyURL: proxy.URL, NoProxy: "direct.example.com"}
+	c := &config.Config{HTTPClient: cfg}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := newHTTPClient(c.HTTPClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Get("http://management.example.com/subscriptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(proxied) != 1 || proxied[0] != "http://management.example.com/subscriptions" {
+		t.Errorf("Expected request to go through the proxy, got %v", proxied)
+	}
+
+	req := httptest.NewRequest("GET", "http://direct.example.com/", nil)
+	if u, err := client.Transport.(*http.Transport).Proxy(req); err != nil || u != nil {
+		t.Errorf("Expected no proxy for a no_proxy host, got %v (%v)", u, err)
+	}
+}
+
+func TestHTTPClientCAFile(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	c := &config.Config{}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	client, err := newHTTPClient(c.HTTPClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatalf("Expected request to a server with an unknown CA to fail")
+	}
+
+	caFile, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(caFile.Name())
+	pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	caFile.Close()
+
+	c.HTTPClient.TLSConfig.CAFile = caFile.Name()
+	client, err = newHTTPClient(c.HTTPClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error with CA file: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestSetHTTPClientDuringScrape(t *testing.T) {
+	srv := setup(t, nil, webAppTarget("BytesReceived"))
+	defer srv.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			if err := ac.setHTTPClient(sc.Get().HTTPClient); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if got := scrape(t); len(got) != 4 {
+			t.Errorf("Expected all aggregations to be exported, got %v", got)
+		}
+	}
+	<-done
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Supported log formats.
+const (
+	logFormatLogfmt = "logfmt"
+	logFormatJSON   = "json"
+)
+
+// logger is replaced according to the log flags on startup.
+var logger = mustNewLogger(os.Stderr, "info", logFormatLogfmt)
+
+// newLogger returns a logger writing to w in the given format, dropping entries
+// below the given level.
+func newLogger(w io.Writer, lvl, format string) (log.Logger, error) {
+	var l log.Logger
+	switch format {
+	case logFormatLogfmt:
+		l = log.NewLogfmtLogger(log.NewSyncWriter(w))
+	case logFormatJSON:
+		l = log.NewJSONLogger(log.NewSyncWriter(w))
+	default:
+		return nil, fmt.Errorf("Unknown log format %q", format)
+	}
+
+	var allowed level.Option
+	switch lvl {
+	case "debug":
+		allowed = level.AllowDebug()
+	case "info":
+		allowed = level.AllowInfo()
+	case "warn":
+		allowed = level.AllowWarn()
+	case "error":
+		allowed = level.AllowError()
+	default:
+		return nil, fmt.Errorf("Unknown log level %q", lvl)
+	}
+	l = level.NewFilter(l, allowed)
+	return log.With(l, "ts", log.DefaultTimestampUTC, "caller", log.DefaultCaller), nil
+}
+
+func mustNewLogger(w io.Writer, lvl, format string) log.Logger {
+	l, err := newLogger(w, lvl, format)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// errorFields returns the key value pairs logged for an error, with the details of Azure API errors
+// as separate fields. Responses without an Azure error are logged with the status code as error.
+func errorFields(err error) []interface{} {
+	if e, ok := err.(*azureError); ok {
+		if e.code == "" {
+			return []interface{}{"status", e.status, "request_id", e.requestID, "err", e.Error()}
+		}
+		return []interface{}{"status", e.status, "code", e.code, "request_id", e.requestID, "err", e.message}
+	}
+	return []interface{}{"err", err}
+}
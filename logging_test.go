@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/RobustPerception/azure_metrics_exporter/azuretest"
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+)
+
+// captureLogs makes the exporter log to the returned buffer until the returned function is called.
+func captureLogs(t *testing.T, lvl, format string) (*bytes.Buffer, func()) {
+	var buf bytes.Buffer
+	l, err := newLogger(&buf, lvl, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := logger
+	logger = l
+	return &buf, func() { logger = prev }
+}
+
+func TestLogRequestContext(t *testing.T) {
+	srv := setup(t, func(_ *azuretest.Server, c *config.Config) {
+		c.Targets[0].Aggregations = []string{"Total"}
+	}, webAppTarget("BytesReceived", "Http5xx"))
+	defer srv.Close()
+
+	buf, restore := captureLogs(t, "info", logFormatLogfmt)
+	defer restore()
+	scrape(t)
+	if buf.Len() != 0 {
+		t.Errorf("Expected no logs at info level for a successful scrape, got %q", buf.String())
+	}
+
+	srv.FailNext(1, http.StatusServiceUnavailable, "ServiceUnavailable", "Service is unavailable")
+	scrape(t)
+	for _, field := range []string{
+		"level=error",
+		`msg="Failed to get metrics"`,
+		"target=" + azuretest.WebAppResource,
+		"metrics=BytesReceived,Http5xx",
+		"status=503",
+		"code=ServiceUnavailable",
+		"request_id=fake-request-2",
+		`err="Service is unavailable"`,
+	} {
+		if !strings.Contains(buf.String(), field) {
+			t.Errorf("Expected log to contain %s, got %q", field, buf.String())
+		}
+	}
+}
+
+func TestErrorFields(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want []interface{}
+	}{
+		{
+			err:  &azureError{status: 503, code: "ServiceUnavailable", message: "Service is unavailable", requestID: "1"},
+			want: []interface{}{"status", 503, "code", "ServiceUnavailable", "request_id", "1", "err", "Service is unavailable"},
+		},
+		{
+			err:  &azureError{status: 502, requestID: "2"},
+			want: []interface{}{"status", 502, "request_id", "2", "err", "Azure API returned status code 502"},
+		},
+	} {
+		if got := errorFields(tc.err); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("errorFields(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestLogDebugJSON(t *testing.T) {
+	srv := setup(t, nil, webAppTarget("BytesReceived"))
+	defer srv.Close()
+
+	buf, restore := captureLogs(t, "debug", logFormatJSON)
+	defer restore()
+	scrape(t)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a single JSON log entry, got %q: %v", buf.String(), err)
+	}
+	if entry["level"] != "debug" || entry["msg"] != "Queried metrics" || entry["target"] != azuretest.WebAppResource || entry["status"] != float64(200) {
+		t.Errorf("Unexpected debug log entry %v", entry)
+	}
+}
+
+func TestNewLoggerErrors(t *testing.T) {
+	if _, err := newLogger(&bytes.Buffer{}, "verbose", logFormatLogfmt); err == nil {
+		t.Errorf("Expected error for unknown log level")
+	}
+	if _, err := newLogger(&bytes.Buffer{}, "info", "text"); err == nil {
+		t.Errorf("Expected error for unknown log format")
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+)
+
+// LogQueryResponse represents the response of the Log Analytics query API.
+type LogQueryResponse struct {
+	Tables []struct {
+		Name    string `json:"name"`
+		Columns []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"columns"`
+		Rows [][]interface{} `json:"rows"`
+	} `json:"tables"`
+	APIError struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// newLogQuery returns a background query running the given log query.
+func newLogQuery(c *config.Config, q *config.LogQuery) *backgroundQuery {
+	return &backgroundQuery{
+		name:     fmt.Sprintf("log query %s", q.Name),
+		interval: time.Duration(q.Interval),
+		run: func() ([]sample, error) {
+			data, err := ac.queryLogs(c, q)
+			if err != nil {
+				return nil, err
+			}
+			return logQuerySamples(c, q, data)
+		},
+	}
+}
+
+// queryLogs runs the log query against its workspace.
+func (ac *AzureClient) queryLogs(c *config.Config, q *config.LogQuery) (LogQueryResponse, error) {
+	token, err := ac.getResourceAccessToken(c, q.Credentials, c.Endpoints.LogAnalytics)
+	if err != nil {
+		return LogQueryResponse{}, fmt.Errorf("Error refreshing access token: %v", err)
+	}
+
+	query := map[string]string{"query": q.Query}
+	if q.Timespan > 0 {
+		query["timespan"] = fmt.Sprintf("PT%dS", int64(time.Duration(q.Timespan).Seconds()))
+	}
+	body, err := json.Marshal(query)
+	if err != nil {
+		return LogQueryResponse{}, fmt.Errorf("Error marshalling query: %v", err)
+	}
+
+	target := fmt.Sprintf("%s/v1/workspaces/%s/query", c.Endpoints.LogAnalytics, q.WorkspaceID)
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return LogQueryResponse{}, fmt.Errorf("Error creating HTTP request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := ac.httpClient().Do(req)
+	if err != nil {
+		return LogQueryResponse{}, fmt.Errorf("Error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return LogQueryResponse{}, fmt.Errorf("Error reading body of response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return LogQueryResponse{}, newAzureError(resp, body)
+	}
+
+	var data LogQueryResponse
+	if err := json.Unmarshal(body, &data); err != nil {
+		return LogQueryResponse{}, fmt.Errorf("Error unmarshalling response body: %v", err)
+	}
+	if data.APIError.Code != "" {
+		return LogQueryResponse{}, fmt.Errorf("Log Analytics API returned error: %s - %v", data.APIError.Code, data.APIError.Message)
+	}
+	return data, nil
+}
+
+// logQuerySamples converts the rows of the primary result table to samples. Rows without
+// a numeric value are skipped. Rows with the same label values would be exported as the same
+// series, so only the first one is kept.
+func logQuerySamples(c *config.Config, q *config.LogQuery, data LogQueryResponse) ([]sample, error) {
+	if len(data.Tables) == 0 {
+		return nil, fmt.Errorf("Query returned no tables")
+	}
+	table := data.Tables[0]
+
+	columns := make(map[string]int, len(table.Columns))
+	for i, column := range table.Columns {
+		columns[column.Name] = i
+	}
+	valueIndex, ok := columns[q.ValueColumn]
+	if !ok {
+		return nil, fmt.Errorf("Value column %q not found in query result", q.ValueColumn)
+	}
+	for _, column := range q.LabelColumns {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("Label column %q not found in query result", column)
+		}
+	}
+
+	samples := []sample{}
+	seen := make(map[uint64]bool)
+	for _, row := range table.Rows {
+		if len(row) != len(table.Columns) {
+			return nil, fmt.Errorf("Query result row has %d columns, expected %d", len(row), len(table.Columns))
+		}
+		value, ok := queryValue(row[valueIndex])
+		if !ok {
+			continue
+		}
+		labels := make(map[string]string, len(q.LabelColumns))
+		for _, column := range q.LabelColumns {
+			labels[column] = queryLabelValue(row[columns[column]])
+		}
+		labels = AddStaticLabels(labels, c.Labels, q.Labels)
+		key := model.LabelsToSignature(labels)
+		if seen[key] {
+			level.Warn(logger).Log("msg", "Skipping query result row, another row has the same labels", "query", q.Name, "labels", fmt.Sprint(labels))
+			continue
+		}
+		seen[key] = true
+		samples = append(samples, sample{name: q.Name, labels: labels, value: value})
+	}
+	return samples, nil
+}
+
+// queryValue returns the numeric value of a query result cell. Numbers may be returned as strings,
+// e.g. for decimal columns.
+func queryValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// queryLabelValue formats a query result cell as label value.
+func queryLabelValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
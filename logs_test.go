@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/azuretest"
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/prometheus/common/model"
+)
+
+func TestCollectLogQueries(t *testing.T) {
+	srv := setup(t, func(srv *azuretest.Server, c *config.Config) {
+		srv.AddWorkspace("workspace-1", azuretest.Table{
+			Columns: []azuretest.Column{{Name: "AppDisplayName", Type: "string"}, {Name: "Failed", Type: "bool"}, {Name: "count_", Type: "long"}},
+			Rows: [][]interface{}{
+				{"portal", true, 12},
+				{"cli", false, "3"},
+				{nil, true, nil},
+			},
+		})
+		c.Labels = map[string]string{"env": "production"}
+		c.LogQueries = []*config.LogQuery{{
+			Name:         "azure_log_signins",
+			WorkspaceID:  "workspace-1",
+			Query:        "SigninLogs | summarize count() by AppDisplayName, Failed",
+			Timespan:     model.Duration(time.Hour),
+			ValueColumn:  "count_",
+			LabelColumns: []string{"AppDisplayName", "Failed"},
+			Labels:       map[string]string{"source": "aad"},
+		}}
+	})
+	defer srv.Close()
+
+	runBackgroundQueries()
+	assertSamples(t, scrape(t),
+		`azure_log_signins{AppDisplayName="cli",Failed="false",env="production",source="aad"} 3`,
+		`azure_log_signins{AppDisplayName="portal",Failed="true",env="production",source="aad"} 12`,
+	)
+
+	reqs := srv.Requests()
+	if len(reqs) != 1 || reqs[0].Path != "/v1/workspaces/workspace-1/query" {
+		t.Fatalf("Expected a single log query request, got %v", reqs)
+	}
+	var body map[string]string
+	if err := json.Unmarshal([]byte(reqs[0].Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["query"] != sc.C.LogQueries[0].Query || body["timespan"] != "PT3600S" {
+		t.Errorf("Unexpected query body %v", body)
+	}
+
+	// Failed runs keep the previous samples.
+	q := newBackgroundQueries(sc.C)[0]
+	q.update()
+	srv.FailNext(1, 500, "InternalServerError", "Query failed")
+	q.update()
+	if len(q.samples) != 2 {
+		t.Errorf("Expected samples of the previous run to be kept, got %v", q.samples)
+	}
+}
+
+func TestLogQueryErrors(t *testing.T) {
+	srv := setup(t, func(srv *azuretest.Server, c *config.Config) {
+		srv.AddWorkspace("workspace-1", azuretest.Table{
+			Columns: []azuretest.Column{{Name: "AppDisplayName", Type: "string"}, {Name: "count_", Type: "long"}},
+			Rows:    [][]interface{}{{"portal", 12}, {"cli", 3}, {"portal", 1}},
+		})
+		c.LogQueries = []*config.LogQuery{{
+			Name:         "azure_log_signins",
+			WorkspaceID:  "workspace-1",
+			Query:        "SigninLogs | summarize count() by AppDisplayName",
+			ValueColumn:  "count_",
+			LabelColumns: []string{"AppDisplayName"},
+		}}
+	})
+	defer srv.Close()
+	q := sc.C.LogQueries[0]
+
+	srv.FailNext(1, 502, "BadGateway", "Bad gateway")
+	_, err := ac.queryLogs(sc.C, q)
+	if e, ok := err.(*azureError); !ok || e.status != 502 || e.code != "BadGateway" {
+		t.Errorf("Expected an Azure error with status 502, got %v", err)
+	}
+
+	data, err := ac.queryLogs(sc.C, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rows with the same labels as a previous one are skipped.
+	samples, err := logQuerySamples(sc.C, q, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 || samples[0].value != 12 || samples[1].value != 3 {
+		t.Errorf("Expected the first rows of each label values, got %v", samples)
+	}
+}
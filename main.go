@@ -1,18 +1,30 @@
 package main
 
 import (
-	"log"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/RobustPerception/azure_metrics_exporter/otlp"
+	"github.com/RobustPerception/azure_metrics_exporter/remotewrite"
+	"github.com/RobustPerception/azure_metrics_exporter/web"
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/protobuf/proto"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/version"
 
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	yaml "gopkg.in/yaml.v2"
 )
 
 var (
@@ -22,7 +34,14 @@ var (
 	ac                    = NewAzureClient()
 	configFile            = kingpin.Flag("config.file", "Azure exporter configuration file.").Default("azure.yml").String()
 	listenAddress         = kingpin.Flag("web.listen-address", "The address to listen on for HTTP requests.").Default(":9276").String()
+	webConfigFile         = kingpin.Flag("web.config.file", "Path to a configuration file enabling TLS or basic authentication.").Default("").String()
 	listMetricDefinitions = kingpin.Flag("list.definitions", "List available metric definitions for the given resources and exit.").Bool()
+	listFormat            = kingpin.Flag("list.format", "Output format of the listed metric definitions and namespaces.").Default("text").Enum("text", "json", "yaml")
+	listMetricNamespaces  = kingpin.Flag("list.namespaces", "List available metric namespaces for the given resources and exit.").Bool()
+	generateConfig        = kingpin.Flag("generate.config", "Print a target configuration querying all available metrics of the given resources and exit.").Bool()
+	logLevel              = kingpin.Flag("log.level", "Only log messages with the given severity or above.").Default("info").Enum("debug", "info", "warn", "error")
+	logFormat             = kingpin.Flag("log.format", "Output format of log messages.").Default(logFormatLogfmt).Enum(logFormatLogfmt, logFormatJSON)
+	collectionMode        = kingpin.Flag("collection.mode", "Whether targets are queried on every scrape (sync) or on their interval in the background, serving the latest results on scrapes (async).").Default(collectionModeSync).Enum(collectionModeSync, collectionModeAsync)
 	invalidMetricChars    = regexp.MustCompile("[^a-zA-Z0-9_:]")
 )
 
@@ -39,72 +58,362 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 // Collect - collect results from Azure Montior API and create Prometheus metrics.
-func (c *Collector) Collect(ch chan<- prometheus.Metric) {
-	// Get metric values for all defined metrics
-	for _, target := range sc.C.Targets {
-		metrics := []string{}
-		for _, metric := range target.Metrics {
-			metrics = append(metrics, metric.Name)
-		}
-		metricsStr := strings.Join(metrics, ",")
-		metricValueData, err := ac.getMetricValue(metricsStr, target)
-		if err != nil {
-			log.Printf("Failed to get metrics for target %s: %v", target.Resource, err)
-			continue
+func (collector *Collector) Collect(ch chan<- prometheus.Metric) {
+	// The whole scrape uses the same configuration, even if it's reloaded meanwhile.
+	c := sc.Get()
+	m := newMetricSender(c)
+	// In the async collection mode, targets are queried by background queries.
+	if *collectionMode != collectionModeAsync {
+		// Get metric values for all defined metrics
+		for _, target := range c.Targets {
+			// Failures are logged, and the metrics that could be fetched are still exported.
+			samples, _ := collectTarget(c, target)
+			for _, s := range latestSamples(samples) {
+				m.add(s)
+			}
 		}
+	}
+	bq.collect(ch, m)
+	m.send(ch)
+	invalidMetricRequests.Collect(ch)
+	remotewrite.Collect(ch)
+	if c.OTLP != nil {
+		otlp.Collect(ch)
+	}
+}
 
-		if len(metricValueData.Value) == 0 || len(metricValueData.Value[0].Timeseries) == 0 {
-			log.Printf("Metric %v not found at target %v\n", metricsStr, target.Resource)
-			continue
+// metricAggregation is an exported aggregation and the suffix of its metric names.
+type metricAggregation struct {
+	aggregation string
+	suffix      string
+}
+
+// metricAggregations are the exported aggregations.
+var metricAggregations = []metricAggregation{
+	{"Total", "_total"},
+	{"Average", "_average"},
+	{"Minimum", "_min"},
+	{"Maximum", "_max"},
+}
+
+// labelsMetricName is the name of all metrics with the labels metric naming.
+const labelsMetricName = "azure_monitor_metric"
+
+// metricSeries names the series of the aggregations of an Azure metric. With normalized
+// units, unit is the Prometheus base unit and values are multiplied by scale to convert them.
+type metricSeries struct {
+	naming     string
+	namespace  string
+	metric     string
+	unit       string
+	normalized bool
+	scale      float64
+	labels     map[string]string
+}
+
+func newMetricSeries(c *config.Config, target config.Target, namespace, metric, unit string, labels map[string]string) metricSeries {
+	m := metricSeries{naming: target.MetricNaming, namespace: namespace, metric: metric, unit: unit, scale: 1, labels: labels}
+	if c.NormalizeUnits {
+		m.unit, m.scale = NormalizeUnit(unit)
+		m.normalized = true
+	}
+	return m
+}
+
+// aggregation returns the name and labels of the series of the aggregation. With the labels
+// naming, the metric, metric_namespace, unit and aggregation labels are added.
+func (m metricSeries) aggregation(a metricAggregation) (string, map[string]string) {
+	if m.naming != config.MetricNamingLabels {
+		if m.normalized {
+			return CreateNormalizedMetricName(m.namespace, m.metric, m.unit) + a.suffix, m.labels
 		}
-		if len(metricValueData.Value[0].Timeseries[0].Data) == 0 {
-			log.Printf("No metric data returned for metric %v at target %v\n", metricsStr, target.Resource)
-			continue
+		return CreateMetricName(m.namespace, m.metric, m.unit) + a.suffix, m.labels
+	}
+	labels := make(map[string]string, len(m.labels)+4)
+	for k, v := range m.labels {
+		labels[k] = v
+	}
+	labels["metric"] = m.metric
+	labels["unit"] = m.unit
+	labels["aggregation"] = strings.ToLower(a.aggregation)
+	// Set even if empty, as all series of a metric must have the same label names.
+	labels["metric_namespace"] = m.namespace
+	return labelsMetricName, labels
+}
+
+// maxMetricsPerRequest is the maximum number of metrics Azure Monitor accepts in a single request.
+const maxMetricsPerRequest = 20
+
+// collectTarget queries all metrics of the target. Failed requests are logged, and the first
+// error is returned along with the samples of the successful ones.
+func collectTarget(c *config.Config, target config.Target) ([]sample, error) {
+	samples := []sample{}
+	var firstErr error
+	for namespace, metrics := range GetMetricsByNamespace(target) {
+		s, err := collectMetrics(c, target, namespace, metrics)
+		samples = append(samples, s...)
+		if err != nil && firstErr == nil {
+			firstErr = err
 		}
+	}
+	return samples, firstErr
+}
 
-		for _, value := range metricValueData.Value {
-			// Ensure Azure metric names conform to Prometheus metric name conventions
-			metricName := strings.Replace(value.Name.Value, " ", "_", -1)
-			metricName = strings.ToLower(metricName + "_" + value.Unit)
-			metricName = strings.Replace(metricName, "/", "_per_", -1)
-			metricName = invalidMetricChars.ReplaceAllString(metricName, "_")
-			metricValue := value.Timeseries[0].Data[len(value.Timeseries[0].Data)-1]
+// collectMetrics queries the given metrics of the target in a single metric namespace, in
+// parallel requests of at most maxMetricsPerRequest metrics. Metrics split by dimensions are
+// queried in requests of their own, as the dimension filter applies to the whole request.
+func collectMetrics(c *config.Config, target config.Target, namespace string, metrics []config.Metric) ([]sample, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		samples  []sample
+		firstErr error
+	)
+	var chunks [][]config.Metric
+	var unsplit []config.Metric
+	for _, m := range metrics {
+		if len(m.Dimensions) > 0 {
+			chunks = append(chunks, []config.Metric{m})
+		} else {
+			unsplit = append(unsplit, m)
+		}
+	}
+	for len(unsplit) > 0 {
+		n := maxMetricsPerRequest
+		if n > len(unsplit) {
+			n = len(unsplit)
+		}
+		chunks = append(chunks, unsplit[:n])
+		unsplit = unsplit[n:]
+	}
+	for _, chunk := range chunks {
+		wg.Add(1)
+		go func(chunk []config.Metric) {
+			defer wg.Done()
+			s, err := collectMetricChunk(c, target, namespace, chunk)
+			mu.Lock()
+			defer mu.Unlock()
+			samples = append(samples, s...)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(chunk)
+	}
+	wg.Wait()
+	return samples, firstErr
+}
+
+// invalidMetricRequests counts the requests of metrics Azure rejected as invalid, so they can
+// be alerted on.
+var invalidMetricRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "azure_exporter_invalid_metric_requests_total",
+	Help: "Requests of metrics of a target resource rejected by Azure as invalid.",
+}, []string{"resource", "metric"})
+
+// collectMetricChunk queries the given metrics of the target in a single request. As one
+// invalid metric makes Azure reject the whole request, rejected requests are split in
+// halves until the invalid metrics are isolated, so the valid ones are still exported.
+func collectMetricChunk(c *config.Config, target config.Target, namespace string, metrics []config.Metric) ([]sample, error) {
+	metricsStr := metricNames(metrics)
+	endTime, startTime := queryTimes(c, target, namespace, strings.Split(metricsStr, ","))
+	metricValueData, err := ac.getMetricValue(c, metrics, namespace, target, startTime, endTime)
+	if isInvalidMetricError(err) {
+		if len(metrics) > 1 {
+			first, err1 := collectMetricChunk(c, target, namespace, metrics[:len(metrics)/2])
+			second, err2 := collectMetricChunk(c, target, namespace, metrics[len(metrics)/2:])
+			if err1 == nil {
+				err1 = err2
+			}
+			return append(first, second...), err1
+		}
+		level.Error(logger).Log(append([]interface{}{"msg", "Invalid metric", "target", target.Resource, "metric", metricsStr}, errorFields(err)...)...)
+		invalidMetricRequests.WithLabelValues(target.Resource, metricsStr).Inc()
+		return nil, nil
+	}
+	if err != nil {
+		level.Error(logger).Log(append([]interface{}{"msg", "Failed to get metrics", "target", target.Resource, "metrics", metricsStr}, errorFields(err)...)...)
+		return nil, err
+	}
+
+	// Only a single metric is queried when splitting by dimensions.
+	split := len(metrics) == 1 && len(metrics[0].Dimensions) > 0
+	samples := []sample{}
+	for _, value := range metricValueData.Value {
+		if len(value.Timeseries) == 0 {
+			level.Warn(logger).Log("msg", "No metric data returned", "target", target.Resource, "metric", value.Name.Value)
+			continue
+		}
+		timeseries := value.Timeseries[:1]
+		if split {
+			timeseries = limitTimeseries(value.Timeseries, metrics[0].Dimensions, metrics[0].MaxSeries)
+		}
+		// The metric is backfilled from the series lagging the most, so none misses data points.
+		// The data points other series already exported are exported again with the same values.
+		var backfillLast time.Time
+		for _, ts := range timeseries {
 			labels := CreateResourceLabels(value.ID)
+			labels = AddStaticLabels(labels, TargetStaticLabels(c, target))
+			if split {
+				for _, d := range metrics[0].Dimensions {
+					labels[config.DimensionLabelName(d)] = ts.dimension(d)
+				}
+			}
+			series := newMetricSeries(c, target, namespace, value.Name.Value, value.Unit, labels)
 
-			if hasAggregation(target, "Total") {
-				ch <- prometheus.MustNewConstMetric(
-					prometheus.NewDesc(metricName+"_total", metricName+"_total", nil, labels),
-					prometheus.GaugeValue,
-					metricValue.Total,
-				)
+			if c.Backfill.Enabled {
+				s, last := backfillSamples(target, series, ts.Data)
+				samples = append(samples, s...)
+				if !last.IsZero() && (backfillLast.IsZero() || last.Before(backfillLast)) {
+					backfillLast = last
+				}
+				continue
 			}
 
-			if hasAggregation(target, "Average") {
-				ch <- prometheus.MustNewConstMetric(
-					prometheus.NewDesc(metricName+"_average", metricName+"_average", nil, labels),
-					prometheus.GaugeValue,
-					metricValue.Average,
-				)
+			// Aggregations without a value in any returned data point are skipped rather than
+			// exported as 0.
+			for _, a := range metricAggregations {
+				if !hasAggregation(target, a.aggregation) {
+					continue
+				}
+				if v, ok := latestValue(ts.Data, a.aggregation); ok {
+					name, labels := series.aggregation(a)
+					samples = append(samples, sample{target: target, name: name, labels: labels, value: v * series.scale})
+				}
 			}
+		}
+		if c.Backfill.Enabled {
+			backfill.update(newBackfillKey(target, namespace, value.Name.Value), backfillLast)
+		}
+	}
+	for i := range samples {
+		samples[i].region = metricValueData.ResourceRegion
+	}
+	return samples, nil
+}
+
+// relabeledSample is a sample after relabeling, as it is exported.
+type relabeledSample struct {
+	name      string
+	labels    map[string]string
+	value     float64
+	timestamp time.Time
+}
+
+// metricSender relabels the samples of a scrape and sends them once all are added. All series
+// of a metric must have the same label names, so label names missing on some series of a
+// metric, e.g. the dimensions of split metrics exported as azure_monitor_metric, are added
+// with an empty value.
+type metricSender struct {
+	c          *config.Config
+	samples    []relabeledSample
+	labelNames map[string]map[string]bool
+}
+
+func newMetricSender(c *config.Config) *metricSender {
+	return &metricSender{c: c, labelNames: map[string]map[string]bool{}}
+}
 
-			if hasAggregation(target, "Minimum") {
+// add relabels the sample, and drops it if relabeling does.
+func (m *metricSender) add(s sample) {
+	name, lset, ok := relabelSample(m.c, s)
+	if !ok {
+		return
+	}
+	if m.labelNames[name] == nil {
+		m.labelNames[name] = map[string]bool{}
+	}
+	for ln := range lset {
+		m.labelNames[name][ln] = true
+	}
+	m.samples = append(m.samples, relabeledSample{name: name, labels: lset, value: s.value, timestamp: s.timestamp})
+}
 
-				ch <- prometheus.MustNewConstMetric(
-					prometheus.NewDesc(metricName+"_min", metricName+"_min", nil, labels),
-					prometheus.GaugeValue,
-					metricValue.Minimum,
-				)
+// send sends all added samples.
+func (m *metricSender) send(ch chan<- prometheus.Metric) {
+	for _, s := range m.samples {
+		for ln := range m.labelNames[s.name] {
+			if _, ok := s.labels[ln]; !ok {
+				s.labels[ln] = ""
 			}
+		}
+		var metric prometheus.Metric = prometheus.MustNewConstMetric(
+			prometheus.NewDesc(s.name, s.name, nil, s.labels),
+			prometheus.GaugeValue,
+			s.value,
+		)
+		if !s.timestamp.IsZero() {
+			metric = timestampedMetric{Metric: metric, timestamp: s.timestamp}
+		}
+		ch <- metric
+	}
+}
 
-			if hasAggregation(target, "Maximum") {
-				ch <- prometheus.MustNewConstMetric(
-					prometheus.NewDesc(metricName+"_max", metricName+"_max", nil, labels),
-					prometheus.GaugeValue,
-					metricValue.Maximum,
-				)
+// relabelSample applies the metric relabel configs of the sample's target and the global
+// ones, and returns the resulting metric name and labels. ok is false if the sample was dropped.
+func relabelSample(c *config.Config, s sample) (name string, labels map[string]string, ok bool) {
+	lset := make(map[string]string, len(s.labels)+1)
+	for k, v := range s.labels {
+		lset[k] = v
+	}
+	lset[model.MetricNameLabel] = s.name
+
+	lset = Relabel(lset, s.target.MetricRelabelConfigs...)
+	if lset == nil {
+		return "", nil, false
+	}
+	lset = Relabel(lset, c.MetricRelabelConfigs...)
+	if lset == nil {
+		return "", nil, false
+	}
+
+	name = lset[model.MetricNameLabel]
+	delete(lset, model.MetricNameLabel)
+	if !model.IsValidMetricName(model.LabelValue(name)) {
+		level.Warn(logger).Log("msg", "Dropping metric with invalid name after relabeling", "metric", name)
+		return "", nil, false
+	}
+	for ln := range lset {
+		if !model.LabelName(ln).IsValid() {
+			level.Warn(logger).Log("msg", "Dropping metric with invalid label name after relabeling", "metric", name, "label", ln)
+			return "", nil, false
+		}
+	}
+	return name, lset, true
+}
+
+// timestampedMetric is a metric exposed with the time of its Azure data point.
+type timestampedMetric struct {
+	prometheus.Metric
+	timestamp time.Time
+}
+
+func (m timestampedMetric) Write(pb *dto.Metric) error {
+	err := m.Metric.Write(pb)
+	pb.TimestampMs = proto.Int64(m.timestamp.UnixNano() / int64(time.Millisecond))
+	return err
+}
+
+// latestSamples returns the most recent sample of every series. Only one sample per series
+// can be exposed per scrape, while backfilled collections return several.
+func latestSamples(samples []sample) []sample {
+	type seriesKey struct {
+		name      string
+		signature uint64
+	}
+	latest := make(map[seriesKey]int, len(samples))
+	result := make([]sample, 0, len(samples))
+	for _, s := range samples {
+		key := seriesKey{s.name, model.LabelsToSignature(s.labels)}
+		if i, ok := latest[key]; ok {
+			if s.timestamp.After(result[i].timestamp) {
+				result[i] = s
 			}
+			continue
 		}
+		latest[key] = len(result)
+		result = append(result, s)
 	}
+	return result
 }
 
 func handler(w http.ResponseWriter, r *http.Request) {
@@ -115,35 +424,146 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	h.ServeHTTP(w, r)
 }
 
+// reloadConfig reloads and validates the configuration file, then recreates the HTTP client
+// and refreshes the access token in case their configuration changed. The remote write
+// queues, the OTLP exporter and background queries are restarted with the new configuration.
+func reloadConfig() error {
+	if err := sc.ReloadConfig(*configFile, checkCollectionMode, ac.validateMetrics); err != nil {
+		return err
+	}
+	level.Info(logger).Log("msg", "Reloaded config file", "file", *configFile)
+	c := sc.Get()
+	if err := ac.setHTTPClient(c.HTTPClient); err != nil {
+		return err
+	}
+	err := ac.refreshAccessTokens(c)
+	if rwErr := rw.start(c); rwErr != nil && err == nil {
+		err = rwErr
+	}
+	if otlpErr := oe.start(c); otlpErr != nil && err == nil {
+		err = otlpErr
+	}
+	bq.start(c)
+	return err
+}
+
 func main() {
 	kingpin.HelpFlag.Short('h')
 	kingpin.Parse()
-	if err := sc.ReloadConfig(*configFile); err != nil {
-		log.Fatalf("Error loading config: %v", err)
+
+	l, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	logger = l
 
-	err := ac.getAccessToken()
-	if err != nil {
-		log.Fatalf("Failed to get token: %v", err)
+	// Validating metrics would get in the way of listing the valid ones.
+	checks := []func(*config.Config) error{checkCollectionMode, ac.validateMetrics}
+	if *listMetricDefinitions || *listMetricNamespaces || *generateConfig {
+		checks = nil
+	}
+	if err := sc.ReloadConfig(*configFile, checks...); err != nil {
+		level.Error(logger).Log("msg", "Error loading config", "err", err)
+		os.Exit(1)
+	}
+
+	c := sc.Get()
+	if err := ac.setHTTPClient(c.HTTPClient); err != nil {
+		level.Error(logger).Log("msg", "Error loading config", "err", err)
+		os.Exit(1)
+	}
+
+	if err := ac.refreshAccessTokens(c); err != nil {
+		level.Error(logger).Log(append([]interface{}{"msg", "Failed to get token"}, errorFields(err)...)...)
+		os.Exit(1)
+	}
+
+	// Print list of available metric namespaces for each resource to console if specified.
+	if *listMetricNamespaces {
+		results, err := ac.getMetricNamespaces(c)
+		if err != nil {
+			level.Error(logger).Log(append([]interface{}{"msg", "Failed to fetch metric namespaces"}, errorFields(err)...)...)
+			os.Exit(1)
+		}
+		if err := PrintNamespaces(os.Stdout, results, *listFormat); err != nil {
+			level.Error(logger).Log("msg", "Failed to print metric namespaces", "err", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
 
 	// Print list of available metric definitions for each resource to console if specified.
-	if *listMetricDefinitions {
-		results, err := ac.getMetricDefinitions()
+	if *listMetricDefinitions || *generateConfig {
+		results, err := ac.getMetricDefinitions(c)
 		if err != nil {
-			log.Fatalf("Failed to fetch metric definitions: %v", err)
+			level.Error(logger).Log(append([]interface{}{"msg", "Failed to fetch metric definitions"}, errorFields(err)...)...)
+			os.Exit(1)
 		}
 
-		for k, v := range results {
-			log.Printf("Resource: %s\n\nAvailable Metrics:\n", strings.Split(k, "/")[6])
-			for _, r := range v.MetricDefinitionResponses {
-				log.Printf("- %s\n", r.Name.Value)
+		if *generateConfig {
+			out, err := yaml.Marshal(struct {
+				Targets []config.Target `yaml:"targets"`
+			}{GenerateTargets(results)})
+			if err != nil {
+				level.Error(logger).Log("msg", "Failed to generate config", "err", err)
+				os.Exit(1)
 			}
+			os.Stdout.Write(out)
+			os.Exit(0)
+		}
+
+		if err := PrintDefinitions(os.Stdout, results, *listFormat); err != nil {
+			level.Error(logger).Log("msg", "Failed to print metric definitions", "err", err)
+			os.Exit(1)
 		}
 		os.Exit(0)
 	}
 
+	if err := rw.start(c); err != nil {
+		level.Error(logger).Log("msg", "Error starting remote write", "err", err)
+		os.Exit(1)
+	}
+	if err := oe.start(c); err != nil {
+		level.Error(logger).Log("msg", "Error starting OTLP export", "err", err)
+		os.Exit(1)
+	}
+	bq.start(c)
+
+	hup := make(chan os.Signal, 1)
+	reloadCh := make(chan chan error)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				if err := reloadConfig(); err != nil {
+					level.Error(logger).Log("msg", "Error reloading config", "err", err)
+				}
+			case rc := <-reloadCh:
+				err := reloadConfig()
+				if err != nil {
+					level.Error(logger).Log("msg", "Error reloading config", "err", err)
+				}
+				rc <- err
+			}
+		}
+	}()
+
+	http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprintf(w, "This endpoint requires a POST request.\n")
+			return
+		}
+
+		rc := make(chan error)
+		reloadCh <- rc
+		if err := <-rc; err != nil {
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+		}
+	})
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
             <head>
@@ -157,9 +577,10 @@ func main() {
 	})
 
 	http.HandleFunc("/metrics", handler)
-	log.Printf("azure_metrics_exporter listening on port %v", *listenAddress)
-	if err := http.ListenAndServe(*listenAddress, nil); err != nil {
-		log.Fatalf("Error starting HTTP server: %v", err)
+	level.Info(logger).Log("msg", "azure_metrics_exporter listening", "address", *listenAddress)
+	server := &http.Server{Addr: *listenAddress}
+	if err := web.ListenAndServe(server, *webConfigFile, logger); err != nil {
+		level.Error(logger).Log("msg", "Error starting HTTP server", "err", err)
 		os.Exit(1)
 	}
 
@@ -0,0 +1,637 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/azuretest"
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+)
+
+// setup starts a fake Azure server with the fixture resources and makes the exporter use it.
+func setup(t *testing.T, c func(*azuretest.Server, *config.Config), targets ...config.Target) *azuretest.Server {
+	srv := azuretest.NewServer(azuretest.WebApp(), azuretest.VM())
+	cfg := srv.Config(targets...)
+	if c != nil {
+		c(srv, cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		srv.Close()
+		t.Fatalf("Invalid config: %v", err)
+	}
+	sc.Lock()
+	sc.C = cfg
+	sc.Unlock()
+
+	ac = NewAzureClient()
+	bq.set(nil)
+	invalidMetricRequests.Reset()
+	backfill = &backfillTracker{last: map[backfillKey]time.Time{}}
+	if err := ac.setHTTPClient(cfg.HTTPClient); err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	if err := ac.refreshAccessTokens(cfg); err != nil {
+		srv.Close()
+		t.Fatalf("Failed to get token: %v", err)
+	}
+	return srv
+}
+
+// runBackgroundQueries runs the background queries of the current configuration once,
+// and makes their samples exported on scrapes.
+func runBackgroundQueries() {
+	queries := newBackgroundQueries(sc.C)
+	for _, q := range queries {
+		q.update()
+	}
+	bq.set(queries)
+}
+
+// scrape returns the sorted samples exposed on /metrics.
+func scrape(t *testing.T) []string {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %d: %s", rec.Code, rec.Body.String())
+	}
+	samples := []string{}
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			samples = append(samples, line)
+		}
+	}
+	sort.Strings(samples)
+	return samples
+}
+
+func assertSamples(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	sort.Strings(want)
+	if len(want) == 0 {
+		want = []string{}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected samples:\ngot:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func webAppTarget(metrics ...string) config.Target {
+	t := config.Target{Resource: azuretest.WebAppResource}
+	for _, m := range metrics {
+		t.Metrics = append(t.Metrics, config.Metric{Name: m})
+	}
+	return t
+}
+
+func TestCollect(t *testing.T) {
+	srv := setup(t, nil, webAppTarget("BytesReceived", "Http5xx"))
+	defer srv.Close()
+
+	assertSamples(t, scrape(t),
+		`bytesreceived_bytes_average{resource_group="blog-group",resource_name="blog"} 1024`,
+		`bytesreceived_bytes_max{resource_group="blog-group",resource_name="blog"} 1536`,
+		`bytesreceived_bytes_min{resource_group="blog-group",resource_name="blog"} 512`,
+		`bytesreceived_bytes_total{resource_group="blog-group",resource_name="blog"} 2048`,
+		`http5xx_count_average{resource_group="blog-group",resource_name="blog"} 2`,
+		`http5xx_count_max{resource_group="blog-group",resource_name="blog"} 3`,
+		`http5xx_count_min{resource_group="blog-group",resource_name="blog"} 1`,
+		`http5xx_count_total{resource_group="blog-group",resource_name="blog"} 8`,
+	)
+
+	reqs := srv.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("Expected a single metrics request, got %d", len(reqs))
+	}
+	if got := reqs[0].Query.Get("metricnames"); got != "BytesReceived,Http5xx" {
+		t.Errorf("Unexpected metricnames %q", got)
+	}
+	if got := reqs[0].Query.Get("aggregation"); got != "Total,Average,Minimum,Maximum" {
+		t.Errorf("Unexpected aggregation %q", got)
+	}
+}
+
+func TestCollectAggregationsAndStaticLabels(t *testing.T) {
+	target := webAppTarget("BytesReceived")
+	target.Aggregations = []string{"Maximum"}
+	target.Labels = map[string]string{"owner": "web", "env": "staging"}
+	srv := setup(t, func(_ *azuretest.Server, c *config.Config) {
+		c.Labels = map[string]string{"env": "production", "cluster": "eu"}
+	}, target)
+	defer srv.Close()
+
+	assertSamples(t, scrape(t),
+		`bytesreceived_bytes_max{cluster="eu",env="staging",owner="web",resource_group="blog-group",resource_name="blog"} 1536`,
+	)
+}
+
+func TestCollectTargetLabelNames(t *testing.T) {
+	web := webAppTarget("BytesReceived")
+	web.Aggregations = []string{"Maximum"}
+	web.Labels = map[string]string{"owner": "web"}
+	blog := webAppTarget("BytesReceived")
+	blog.Aggregations = []string{"Maximum"}
+	blog.Labels = map[string]string{"team": "blog"}
+	srv := setup(t, nil, web, blog)
+	defer srv.Close()
+
+	assertSamples(t, scrape(t),
+		`bytesreceived_bytes_max{owner="",resource_group="blog-group",resource_name="blog",team="blog"} 1536`,
+		`bytesreceived_bytes_max{owner="web",resource_group="blog-group",resource_name="blog",team=""} 1536`,
+	)
+}
+
+func TestCollectMetricRelabelConfigs(t *testing.T) {
+	target := webAppTarget("BytesReceived", "Http5xx")
+	target.MetricRelabelConfigs = []*config.RelabelConfig{{
+		SourceLabels: []string{"__name__"},
+		Regex:        config.MustNewRegexp("http5xx_.*"),
+		Action:       config.RelabelDrop,
+	}}
+	srv := setup(t, func(_ *azuretest.Server, c *config.Config) {
+		c.MetricRelabelConfigs = []*config.RelabelConfig{
+			{
+				SourceLabels: []string{"__name__"},
+				Regex:        config.MustNewRegexp(".*_(total|average)"),
+				Action:       config.RelabelKeep,
+			},
+			{
+				SourceLabels: []string{"resource_name"},
+				Separator:    ";",
+				Regex:        config.MustNewRegexp("(.*)"),
+				TargetLabel:  "app",
+				Replacement:  "$1",
+				Action:       config.RelabelReplace,
+			},
+			{
+				Regex:  config.MustNewRegexp("resource_group"),
+				Action: config.RelabelLabelDrop,
+			},
+		}
+	}, target)
+	defer srv.Close()
+
+	assertSamples(t, scrape(t),
+		`bytesreceived_bytes_average{app="blog",resource_name="blog"} 1024`,
+		`bytesreceived_bytes_total{app="blog",resource_name="blog"} 2048`,
+	)
+}
+
+func TestCollectMetricNamespace(t *testing.T) {
+	target := config.Target{
+		Resource:        azuretest.VMResource,
+		MetricNamespace: "azure.vm.windows.guestmetrics",
+		Aggregations:    []string{"Average"},
+		Metrics: []config.Metric{
+			{Name: "Memory\\Available Bytes"},
+			{Name: "Percentage CPU", MetricNamespace: "Microsoft.Compute/virtualMachines"},
+		},
+	}
+	srv := setup(t, nil, target)
+	defer srv.Close()
+
+	assertSamples(t, scrape(t),
+		`azure_vm_windows_guestmetrics_memory_available_bytes_bytes_average{resource_group="vm-group",resource_name="vm1"} 2048`,
+		`microsoft_compute_virtualmachines_percentage_cpu_percent_average{resource_group="vm-group",resource_name="vm1"} 21`,
+	)
+
+	namespaces := map[string]bool{}
+	for _, r := range srv.Requests() {
+		namespaces[r.Query.Get("metricnamespace")] = true
+	}
+	if !reflect.DeepEqual(namespaces, map[string]bool{"azure.vm.windows.guestmetrics": true, "Microsoft.Compute/virtualMachines": true}) {
+		t.Errorf("Unexpected metric namespaces queried: %v", namespaces)
+	}
+}
+
+func TestCollectLabelsNaming(t *testing.T) {
+	webApp := webAppTarget("Http5xx")
+	webApp.Aggregations = []string{"Total", "Maximum"}
+	vm := config.Target{
+		Resource:     azuretest.VMResource,
+		Aggregations: []string{"Average"},
+		Metrics:      []config.Metric{{Name: "Percentage CPU", MetricNamespace: "Microsoft.Compute/virtualMachines"}},
+	}
+	legacy := vm
+	legacy.MetricNaming = config.MetricNamingName
+	// Static labels and dimensions of some series are added empty to the others.
+	split := config.Target{
+		Resource:     azuretest.WebAppResource,
+		Aggregations: []string{"Total"},
+		Labels:       map[string]string{"owner": "web"},
+		Metrics:      []config.Metric{{Name: "Http5xx", Dimensions: []string{"Instance"}}},
+	}
+	srv := setup(t, func(_ *azuretest.Server, c *config.Config) {
+		c.MetricNaming = config.MetricNamingLabels
+	}, webApp, vm, legacy, split)
+	defer srv.Close()
+
+	assertSamples(t, scrape(t),
+		`azure_monitor_metric{aggregation="maximum",instance="",metric="Http5xx",metric_namespace="",owner="",resource_group="blog-group",resource_name="blog",unit="Count"} 3`,
+		`azure_monitor_metric{aggregation="total",instance="",metric="Http5xx",metric_namespace="",owner="",resource_group="blog-group",resource_name="blog",unit="Count"} 8`,
+		`azure_monitor_metric{aggregation="total",instance="instance-1",metric="Http5xx",metric_namespace="",owner="web",resource_group="blog-group",resource_name="blog",unit="Count"} 3`,
+		`azure_monitor_metric{aggregation="total",instance="instance-2",metric="Http5xx",metric_namespace="",owner="web",resource_group="blog-group",resource_name="blog",unit="Count"} 5`,
+		`azure_monitor_metric{aggregation="average",instance="",metric="Percentage CPU",metric_namespace="Microsoft.Compute/virtualMachines",owner="",resource_group="vm-group",resource_name="vm1",unit="Percent"} 21`,
+		`microsoft_compute_virtualmachines_percentage_cpu_percent_average{owner="",resource_group="vm-group",resource_name="vm1"} 21`,
+	)
+}
+
+func TestCollectNormalizedUnits(t *testing.T) {
+	webApp := azuretest.WebApp()
+	webApp.Metrics = append(webApp.Metrics, azuretest.Metric{
+		Name:       "AverageResponseTime",
+		Unit:       "MilliSeconds",
+		Timeseries: []azuretest.Timeseries{{Data: []azuretest.DataPoint{azuretest.Point(azuretest.FixtureTime, 0, 250, 0, 0)}}},
+	})
+	target := webAppTarget("BytesReceived", "Http5xx", "AverageResponseTime")
+	target.Aggregations = []string{"Average"}
+	vm := config.Target{
+		Resource:        azuretest.VMResource,
+		MetricNamespace: "azure.vm.windows.guestmetrics",
+		Aggregations:    []string{"Average"},
+		Metrics: []config.Metric{
+			{Name: "Memory\\Available Bytes"},
+			{Name: "Percentage CPU", MetricNamespace: "Microsoft.Compute/virtualMachines"},
+		},
+	}
+	labels := webAppTarget("AverageResponseTime")
+	labels.Aggregations = []string{"Average"}
+	labels.MetricNaming = config.MetricNamingLabels
+	srv := setup(t, func(srv *azuretest.Server, c *config.Config) {
+		srv.AddResource(webApp)
+		c.NormalizeUnits = true
+	}, target, vm, labels)
+	defer srv.Close()
+
+	assertSamples(t, scrape(t),
+		`averageresponsetime_seconds_average{resource_group="blog-group",resource_name="blog"} 0.25`,
+		`azure_monitor_metric{aggregation="average",metric="AverageResponseTime",metric_namespace="",resource_group="blog-group",resource_name="blog",unit="seconds"} 0.25`,
+		`azure_vm_windows_guestmetrics_memory_available_bytes_average{resource_group="vm-group",resource_name="vm1"} 2048`,
+		`bytesreceived_bytes_average{resource_group="blog-group",resource_name="blog"} 1024`,
+		`http5xx_average{resource_group="blog-group",resource_name="blog"} 2`,
+		`microsoft_compute_virtualmachines_percentage_cpu_ratio_average{resource_group="vm-group",resource_name="vm1"} 0.21`,
+	)
+}
+
+func TestCollectDimensions(t *testing.T) {
+	webApp := azuretest.WebApp()
+	for i, m := range webApp.Metrics {
+		if m.Name == "Requests" {
+			for path, p := range map[string]azuretest.DataPoint{
+				"/a": azuretest.Point(azuretest.FixtureTime, 10, 5, 1, 9),
+				"/b": azuretest.Point(azuretest.FixtureTime, 40, 20, 2, 38),
+				"/c": azuretest.Point(azuretest.FixtureTime, 20, 10, 3, 15),
+				"/d": azuretest.Point(azuretest.FixtureTime, 30, 15, 4, 25),
+				"/e": azuretest.Point(azuretest.FixtureTime, 5, 5, 5, 5),
+			} {
+				webApp.Metrics[i].Timeseries = append(webApp.Metrics[i].Timeseries, azuretest.Timeseries{
+					Metadata: map[string]string{"url": path},
+					Data:     []azuretest.DataPoint{p},
+				})
+			}
+		}
+	}
+	target := config.Target{
+		Resource:     azuretest.WebAppResource,
+		Aggregations: []string{"Total", "Average", "Maximum"},
+		Metrics: []config.Metric{
+			{Name: "BytesReceived"},
+			{Name: "Requests", Dimensions: []string{"Url"}, Top: 4, OrderBy: "Total desc", MaxSeries: 2},
+			{Name: "Http5xx", Dimensions: []string{"Instance"}},
+		},
+	}
+	srv := setup(t, func(srv *azuretest.Server, c *config.Config) {
+		srv.AddResource(webApp)
+	}, target)
+	defer srv.Close()
+
+	assertSamples(t, scrape(t),
+		`bytesreceived_bytes_average{resource_group="blog-group",resource_name="blog"} 1024`,
+		`bytesreceived_bytes_max{resource_group="blog-group",resource_name="blog"} 1536`,
+		`bytesreceived_bytes_total{resource_group="blog-group",resource_name="blog"} 2048`,
+		`http5xx_count_average{instance="instance-1",resource_group="blog-group",resource_name="blog"} 1.5`,
+		`http5xx_count_average{instance="instance-2",resource_group="blog-group",resource_name="blog"} 2.5`,
+		`http5xx_count_max{instance="instance-1",resource_group="blog-group",resource_name="blog"} 2`,
+		`http5xx_count_max{instance="instance-2",resource_group="blog-group",resource_name="blog"} 3`,
+		`http5xx_count_total{instance="instance-1",resource_group="blog-group",resource_name="blog"} 3`,
+		`http5xx_count_total{instance="instance-2",resource_group="blog-group",resource_name="blog"} 5`,
+		`requests_count_average{resource_group="blog-group",resource_name="blog",url="/b"} 20`,
+		`requests_count_average{resource_group="blog-group",resource_name="blog",url="/d"} 15`,
+		`requests_count_max{resource_group="blog-group",resource_name="blog",url="/b"} 38`,
+		`requests_count_max{resource_group="blog-group",resource_name="blog",url="/d"} 25`,
+		`requests_count_max{resource_group="blog-group",resource_name="blog",url="other"} 15`,
+		`requests_count_total{resource_group="blog-group",resource_name="blog",url="/b"} 40`,
+		`requests_count_total{resource_group="blog-group",resource_name="blog",url="/d"} 30`,
+		`requests_count_total{resource_group="blog-group",resource_name="blog",url="other"} 30`,
+	)
+
+	queries := map[string]url.Values{}
+	for _, r := range srv.Requests() {
+		queries[r.Query.Get("metricnames")] = r.Query
+	}
+	if len(queries) != 3 {
+		t.Fatalf("Expected a request per split metric, got %v", queries)
+	}
+	for metric, want := range map[string][3]string{
+		"BytesReceived": {"", "", ""},
+		"Requests":      {"Url eq '*'", "4", "Total desc"},
+		"Http5xx":       {"Instance eq '*'", "", ""},
+	} {
+		q := queries[metric]
+		if got := [3]string{q.Get("$filter"), q.Get("top"), q.Get("orderby")}; got != want {
+			t.Errorf("%s: expected $filter, top and orderby %q, got %q", metric, want, got)
+		}
+	}
+}
+
+func TestCollectCredentialProfiles(t *testing.T) {
+	vm := config.Target{
+		Resource:     azuretest.VMResource,
+		Credentials:  "customer",
+		Aggregations: []string{"Average"},
+		Metrics:      []config.Metric{{Name: "Percentage CPU"}},
+	}
+	web := webAppTarget("BytesReceived")
+	web.Aggregations = []string{"Total"}
+
+	var customerToken string
+	srv := setup(t, func(srv *azuretest.Server, c *config.Config) {
+		customerToken = srv.AddClient("customer-tenant", "customer-client", "customer-secret")
+		c.CredentialProfiles = map[string]config.Credentials{
+			"customer": {
+				SubscriptionID: azuretest.SubscriptionID,
+				TenantID:       "customer-tenant",
+				ClientID:       "customer-client",
+				ClientSecret:   "customer-secret",
+			},
+		}
+	}, web, vm)
+	defer srv.Close()
+
+	assertSamples(t, scrape(t),
+		`bytesreceived_bytes_total{resource_group="blog-group",resource_name="blog"} 2048`,
+		`percentage_cpu_percent_average{resource_group="vm-group",resource_name="vm1"} 21`,
+	)
+
+	for _, r := range srv.Requests() {
+		want := azuretest.AccessToken
+		if strings.Contains(r.Path, azuretest.VMResource) {
+			want = customerToken
+		}
+		if r.Token != want {
+			t.Errorf("Request to %s used token %q, want %q", r.Path, r.Token, want)
+		}
+	}
+}
+
+func TestCollectDuringReload(t *testing.T) {
+	srv := setup(t, nil, webAppTarget("BytesReceived"))
+	defer srv.Close()
+
+	// Scrapes must use a single configuration while it's replaced concurrently.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			c := *sc.Get()
+			c.Labels = map[string]string{"reload": fmt.Sprint(i)}
+			sc.Lock()
+			sc.C = &c
+			sc.Unlock()
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		for _, s := range scrape(t) {
+			if !strings.HasPrefix(s, "bytesreceived_bytes_") {
+				t.Errorf("Unexpected sample %s", s)
+			}
+		}
+	}
+	<-done
+}
+
+func TestCollectErrors(t *testing.T) {
+	srv := setup(t, func(_ *azuretest.Server, c *config.Config) {
+		c.Targets[0].Aggregations = []string{"Total"}
+	}, webAppTarget("BytesReceived"))
+	defer srv.Close()
+
+	srv.FailNext(1, http.StatusInternalServerError, "InternalServerError", "Something went wrong.")
+	assertSamples(t, scrape(t))
+
+	srv.Throttle(1, 0)
+	assertSamples(t, scrape(t))
+
+	assertSamples(t, scrape(t),
+		`bytesreceived_bytes_total{resource_group="blog-group",resource_name="blog"} 2048`,
+	)
+
+	sc.C.Targets = append(sc.C.Targets, webAppTarget("Unknown"))
+	assertSamples(t, scrape(t),
+		`azure_exporter_invalid_metric_requests_total{metric="Unknown",resource="/resourceGroups/blog-group/providers/Microsoft.Web/sites/blog"} 1`,
+		`bytesreceived_bytes_total{resource_group="blog-group",resource_name="blog"} 2048`,
+	)
+}
+
+func TestCollectEmptyMetrics(t *testing.T) {
+	res := azuretest.WebApp()
+	res.Metrics = append(res.Metrics,
+		azuretest.Metric{Name: "Empty", Unit: "Count"},
+		azuretest.Metric{Name: "NoData", Unit: "Count", Timeseries: []azuretest.Timeseries{{}}},
+	)
+	srv := setup(t, func(srv *azuretest.Server, c *config.Config) {
+		srv.AddResource(res)
+		c.Targets[0].Aggregations = []string{"Total"}
+	}, webAppTarget("Empty", "NoData", "BytesReceived"))
+	defer srv.Close()
+
+	// The metrics without data don't hide the other metrics of the request.
+	assertSamples(t, scrape(t),
+		`bytesreceived_bytes_total{resource_group="blog-group",resource_name="blog"} 2048`,
+	)
+	if reqs := srv.Requests(); len(reqs) != 1 {
+		t.Errorf("Expected a single metrics request, got %d", len(reqs))
+	}
+}
+
+func TestCollectBadRequest(t *testing.T) {
+	srv := setup(t, nil, webAppTarget("BytesReceived", "Http5xx"))
+	defer srv.Close()
+
+	// Only requests rejected for an invalid metric are split, other bad requests fail for
+	// every metric.
+	srv.FailNext(1, http.StatusBadRequest, "BadRequest", "Invalid timespan format.")
+	assertSamples(t, scrape(t))
+	if reqs := srv.Requests(); len(reqs) != 1 {
+		t.Errorf("Expected a single metrics request, got %d", len(reqs))
+	}
+}
+
+func TestCollectChunks(t *testing.T) {
+	res := azuretest.WebApp()
+	names := []string{}
+	want := []string{}
+	for i := 1; i <= 45; i++ {
+		name := fmt.Sprintf("Metric%02d", i)
+		res.Metrics = append(res.Metrics, azuretest.Metric{
+			Name:       name,
+			Unit:       "Count",
+			Timeseries: []azuretest.Timeseries{{Data: []azuretest.DataPoint{azuretest.Point(azuretest.FixtureTime, float64(i), 0, 0, 0)}}},
+		})
+		names = append(names, name)
+		want = append(want, fmt.Sprintf(`metric%02d_count_total{resource_group="blog-group",resource_name="blog"} %d`, i, i))
+	}
+	// An invalid metric makes Azure reject the whole request it is part of.
+	names = append(names[:30], append([]string{"Unknown"}, names[30:]...)...)
+
+	buf, restore := captureLogs(t, "info", logFormatLogfmt)
+	defer restore()
+	srv := setup(t, func(srv *azuretest.Server, c *config.Config) {
+		srv.AddResource(res)
+		c.Targets[0].Aggregations = []string{"Total"}
+	}, webAppTarget(names...))
+	defer srv.Close()
+
+	want = append(want, `azure_exporter_invalid_metric_requests_total{metric="Unknown",resource="/resourceGroups/blog-group/providers/Microsoft.Web/sites/blog"} 1`)
+	assertSamples(t, scrape(t), want...)
+
+	requested := map[string]int{}
+	for _, r := range srv.Requests() {
+		metrics := strings.Split(r.Query.Get("metricnames"), ",")
+		if len(metrics) > azuretest.MaxMetricsPerRequest {
+			t.Errorf("Request for %d metrics exceeds the limit", len(metrics))
+		}
+		for _, m := range metrics {
+			requested[m]++
+		}
+	}
+	if len(requested) != len(names) {
+		t.Errorf("Expected all %d metrics to be requested, got %d", len(names), len(requested))
+	}
+	// The chunks without the invalid metric are requested once.
+	for _, m := range append(names[:20], names[40:]...) {
+		if requested[m] != 1 {
+			t.Errorf("Expected metric %s to be requested once, got %d", m, requested[m])
+		}
+	}
+	if !strings.Contains(buf.String(), `msg="Invalid metric"`) || !strings.Contains(buf.String(), "metric=Unknown") {
+		t.Errorf("Expected invalid metric to be logged, got %q", buf.String())
+	}
+	if strings.Count(buf.String(), "level=error") != 1 {
+		t.Errorf("Expected a single error to be logged, got %q", buf.String())
+	}
+}
+
+func TestCollectMissingValues(t *testing.T) {
+	res := azuretest.WebApp()
+	res.Metrics = append(res.Metrics,
+		azuretest.Metric{
+			// Azure returns the current, still empty, time grain as a data point without values.
+			Name: "CpuTime",
+			Unit: "Seconds",
+			Timeseries: []azuretest.Timeseries{{Data: []azuretest.DataPoint{
+				azuretest.Point(azuretest.FixtureTime.Add(-2*time.Minute), 4, 2, 1, 3),
+				{TimeStamp: azuretest.FixtureTime.Add(-time.Minute), Total: azuretest.F(0), Maximum: azuretest.F(0)},
+				{TimeStamp: azuretest.FixtureTime},
+			}}},
+		},
+		azuretest.Metric{
+			Name:       "Idle",
+			Unit:       "Count",
+			Timeseries: []azuretest.Timeseries{{Data: []azuretest.DataPoint{{TimeStamp: azuretest.FixtureTime}}}},
+		},
+	)
+	srv := setup(t, func(srv *azuretest.Server, c *config.Config) {
+		srv.AddResource(res)
+	}, webAppTarget("CpuTime", "Idle"))
+	defer srv.Close()
+
+	assertSamples(t, scrape(t),
+		`cputime_seconds_average{resource_group="blog-group",resource_name="blog"} 2`,
+		`cputime_seconds_max{resource_group="blog-group",resource_name="blog"} 0`,
+		`cputime_seconds_min{resource_group="blog-group",resource_name="blog"} 1`,
+		`cputime_seconds_total{resource_group="blog-group",resource_name="blog"} 0`,
+	)
+}
+
+func TestValidateMetrics(t *testing.T) {
+	srv := setup(t, nil)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		mode    string
+		err     bool
+		metrics []string
+	}{
+		{mode: config.ValidationWarn, metrics: []string{"BytesReceived", "Unknown", "Requests", "Requests", "Http5xx"}},
+		{mode: config.ValidationFail, err: true},
+		{mode: config.ValidationDrop, metrics: []string{"BytesReceived", "Requests"}},
+		{mode: config.ValidationNone, metrics: []string{"BytesReceived", "Unknown", "Requests", "Requests", "Http5xx"}},
+	} {
+		target := webAppTarget("BytesReceived", "Unknown", "Requests")
+		// Split by a required dimension, and by one the metric doesn't have.
+		target.Metrics = append(target.Metrics,
+			config.Metric{Name: "Requests", Dimensions: []string{"url"}},
+			config.Metric{Name: "Http5xx", Dimensions: []string{"Region"}},
+		)
+		c := srv.Config(target)
+		c.ValidationMode = tc.mode
+		if err := c.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		err := ac.validateMetrics(c)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: expected error", tc.mode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.mode, err)
+			continue
+		}
+		metrics := []string{}
+		for _, m := range c.Targets[0].Metrics {
+			metrics = append(metrics, m.Name)
+		}
+		if !reflect.DeepEqual(metrics, tc.metrics) {
+			t.Errorf("%s: expected metrics %v, got %v", tc.mode, tc.metrics, metrics)
+		}
+	}
+}
+
+func TestGenerateTargets(t *testing.T) {
+	target := webAppTarget("BytesReceived")
+	target.Credentials = "web"
+	target.Labels = map[string]string{"owner": "web"}
+	srv := setup(t, func(_ *azuretest.Server, c *config.Config) {
+		c.CredentialProfiles = map[string]config.Credentials{"web": c.Credentials}
+	}, target)
+	defer srv.Close()
+
+	defs, err := ac.getMetricDefinitions(sc.C)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := GenerateTargets(defs)
+	if len(targets) != 1 {
+		t.Fatalf("Expected 1 target, got %d", len(targets))
+	}
+	if !reflect.DeepEqual(targets[0].Aggregations, []string{"Total"}) {
+		t.Errorf("Unexpected aggregations %v", targets[0].Aggregations)
+	}
+	if !reflect.DeepEqual(targets[0].Metrics, []config.Metric{{Name: "BytesReceived"}, {Name: "Http5xx"}}) {
+		t.Errorf("Unexpected metrics %v", targets[0].Metrics)
+	}
+	if targets[0].Credentials != "web" || !reflect.DeepEqual(targets[0].Labels, target.Labels) {
+		t.Errorf("Expected the credentials profile and labels of the target, got %q and %v", targets[0].Credentials, targets[0].Labels)
+	}
+}
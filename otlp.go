@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/RobustPerception/azure_metrics_exporter/otlp"
+	"github.com/prometheus/common/version"
+)
+
+// otlpExporter holds the OTLP exporter of the current configuration, if any.
+type otlpExporter struct {
+	mu       sync.Mutex
+	exporter *otlp.Exporter
+}
+
+var oe = &otlpExporter{}
+
+// start replaces the OTLP exporter with the one of the given configuration. The requests
+// queued on the previous exporter are sent before.
+func (o *otlpExporter) start(c *config.Config) error {
+	var exporter *otlp.Exporter
+	if c.OTLP != nil {
+		client, err := newHTTPClient(c.OTLP.HTTPClient)
+		if err != nil {
+			return fmt.Errorf("Error creating OTLP HTTP client: %v", err)
+		}
+		exporter = otlp.NewExporter(*c.OTLP, client, logger)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.exporter != nil {
+		o.exporter.Stop()
+	}
+	o.exporter = exporter
+	if exporter != nil {
+		exporter.Start()
+	}
+	return nil
+}
+
+// push exports the samples. Samples without the timestamp of an Azure data point are
+// exported with the given time.
+func (o *otlpExporter) push(c *config.Config, samples []sample, now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.exporter == nil || len(samples) == 0 {
+		return
+	}
+	o.exporter.Export(otlpRequest(c, samples, now))
+}
+
+// otlpRequest converts the samples to an OTLP export request after relabeling them. Every
+// metric becomes a gauge, grouped by the Azure resource it belongs to.
+func otlpRequest(c *config.Config, samples []sample, now time.Time) *otlp.ExportMetricsServiceRequest {
+	req := &otlp.ExportMetricsServiceRequest{}
+	resources := map[string]*otlp.ResourceMetrics{}
+	metrics := map[string]map[string]*otlp.Metric{}
+	for _, s := range samples {
+		name, lset, ok := relabelSample(c, s)
+		if !ok {
+			continue
+		}
+
+		resourceID := ""
+		if s.target.Resource != "" {
+			resourceID = fmt.Sprintf("/subscriptions/%s%s", c.TargetCredentials(s.target).SubscriptionID, s.target.Resource)
+		}
+		rm, ok := resources[resourceID]
+		if !ok {
+			rm = &otlp.ResourceMetrics{
+				Resource:     &otlp.Resource{Attributes: otlpResourceAttributes(resourceID)},
+				ScopeMetrics: []*otlp.ScopeMetrics{{Scope: &otlp.InstrumentationScope{Name: "azure_metrics_exporter", Version: version.Version}}},
+			}
+			resources[resourceID] = rm
+			metrics[resourceID] = map[string]*otlp.Metric{}
+			req.ResourceMetrics = append(req.ResourceMetrics, rm)
+		}
+		if s.region != "" && !hasAttribute(rm.Resource.Attributes, "cloud.region") {
+			rm.Resource.Attributes = append(rm.Resource.Attributes, otlp.StringAttribute("cloud.region", s.region))
+		}
+
+		m, ok := metrics[resourceID][name]
+		if !ok {
+			m = &otlp.Metric{Name: name, Gauge: &otlp.Gauge{}}
+			metrics[resourceID][name] = m
+			rm.ScopeMetrics[0].Metrics = append(rm.ScopeMetrics[0].Metrics, m)
+		}
+		timestamp := s.timestamp
+		if timestamp.IsZero() {
+			timestamp = now
+		}
+		value := s.value
+		attributes := make([]*otlp.KeyValue, 0, len(lset))
+		for _, ln := range sortedLabelNames(lset) {
+			attributes = append(attributes, otlp.StringAttribute(ln, lset[ln]))
+		}
+		m.Gauge.DataPoints = append(m.Gauge.DataPoints, &otlp.NumberDataPoint{
+			TimeUnixNano: uint64(timestamp.UnixNano()),
+			AsDouble:     &value,
+			Attributes:   attributes,
+		})
+	}
+	return req
+}
+
+// otlpResourceAttributes returns the resource attributes of the Azure resource with the given
+// ID, only the cloud provider for samples not belonging to a resource.
+func otlpResourceAttributes(resourceID string) []*otlp.KeyValue {
+	attributes := []*otlp.KeyValue{otlp.StringAttribute("cloud.provider", "azure")}
+	if resourceID == "" {
+		return attributes
+	}
+	// /subscriptions/<subscription>/resourceGroups/<group>/providers/...
+	parts := strings.Split(resourceID, "/")
+	attributes = append(attributes,
+		otlp.StringAttribute("cloud.account.id", parts[2]),
+		otlp.StringAttribute("cloud.resource_id", resourceID),
+	)
+	if len(parts) > 4 && strings.EqualFold(parts[3], "resourceGroups") {
+		attributes = append(attributes, otlp.StringAttribute("azure.resource_group", parts[4]))
+	}
+	return attributes
+}
+
+func hasAttribute(attributes []*otlp.KeyValue, key string) bool {
+	for _, a := range attributes {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedLabelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for ln := range labels {
+		names = append(names, ln)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,233 @@
+// Package otlp exports samples as gauges to OpenTelemetry collectors, over HTTP with
+// protobuf encoding.
+package otlp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// queueCapacity is the number of export requests buffered before new ones are dropped.
+	queueCapacity = 100
+	// maxRetries is the number of times a request failing with a retryable error is retried,
+	// with exponential backoff between minBackoff and maxBackoff.
+	maxRetries = 10
+	minBackoff = 30 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
+var (
+	dataPointsSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "azure_exporter_otlp_data_points_sent_total",
+		Help: "Data points exported to the OpenTelemetry collector.",
+	})
+	dataPointsFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "azure_exporter_otlp_data_points_failed_total",
+		Help: "Data points that could not be exported to the OpenTelemetry collector after retrying, or were rejected by it.",
+	})
+	dataPointsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "azure_exporter_otlp_data_points_dropped_total",
+		Help: "Data points dropped because the export queue was full.",
+	})
+)
+
+// Collect sends the metrics about the exported data points.
+func Collect(ch chan<- prometheus.Metric) {
+	dataPointsSent.Collect(ch)
+	dataPointsFailed.Collect(ch)
+	dataPointsDropped.Collect(ch)
+}
+
+// recoverableError is a failure of a request that may succeed when retried.
+type recoverableError struct {
+	error
+}
+
+// Exporter sends export requests to an OpenTelemetry collector in the background.
+type Exporter struct {
+	cfg    config.OTLPConfig
+	client *http.Client
+	logger log.Logger
+
+	requests chan *ExportMetricsServiceRequest
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewExporter returns an exporter to the collector of the given configuration, using the
+// given client. The exporter must be started to send requests.
+func NewExporter(cfg config.OTLPConfig, client *http.Client, logger log.Logger) *Exporter {
+	return &Exporter{
+		cfg:      cfg,
+		client:   client,
+		logger:   log.With(logger, "endpoint", cfg.Endpoint),
+		requests: make(chan *ExportMetricsServiceRequest, queueCapacity),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start starts sending the queued requests.
+func (e *Exporter) Start() {
+	go e.run()
+}
+
+// Stop sends the queued requests once, without retrying, and stops the exporter.
+func (e *Exporter) Stop() {
+	close(e.stop)
+	<-e.done
+}
+
+// Export queues the request. If the queue is full, the request is dropped.
+func (e *Exporter) Export(req *ExportMetricsServiceRequest) {
+	select {
+	case e.requests <- req:
+	default:
+		dataPointsDropped.Add(float64(countDataPoints(req)))
+		level.Warn(e.logger).Log("msg", "OTLP export queue is full, dropping data points")
+	}
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	for {
+		select {
+		case <-e.stop:
+			for {
+				select {
+				case req := <-e.requests:
+					e.send(req, false)
+				default:
+					return
+				}
+			}
+		case req := <-e.requests:
+			e.send(req, true)
+		}
+	}
+}
+
+// send exports the request, retrying recoverable errors with exponential backoff if retry
+// is set.
+func (e *Exporter) send(req *ExportMetricsServiceRequest, retry bool) {
+	n := countDataPoints(req)
+	body, err := proto.Marshal(req)
+	if err != nil {
+		dataPointsFailed.Add(float64(n))
+		level.Error(e.logger).Log("msg", "Failed to encode OTLP request", "err", err)
+		return
+	}
+
+	var resp *ExportMetricsServiceResponse
+	backoff := minBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err = e.post(body)
+		if err == nil {
+			break
+		}
+		if _, ok := err.(recoverableError); !ok || !retry || attempt >= maxRetries {
+			dataPointsFailed.Add(float64(n))
+			level.Error(e.logger).Log("msg", "Failed to export data points", "count", n, "err", err)
+			return
+		}
+		level.Warn(e.logger).Log("msg", "Failed to export data points, retrying", "backoff", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-e.stop:
+			// Give the request a last try without waiting for the backoff.
+			retry = false
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+
+	rejected := int64(0)
+	if p := resp.PartialSuccess; p != nil && p.RejectedDataPoints > 0 {
+		rejected = p.RejectedDataPoints
+		level.Warn(e.logger).Log("msg", "Collector rejected data points", "count", rejected, "err", p.ErrorMessage)
+	}
+	dataPointsFailed.Add(float64(rejected))
+	dataPointsSent.Add(float64(int64(n) - rejected))
+}
+
+// post sends the request with OTLP/HTTP. Network errors, throttling and server errors are
+// recoverable.
+func (e *Exporter) post(body []byte) (*ExportMetricsServiceResponse, error) {
+	req, err := http.NewRequest("POST", e.cfg.Endpoint+"/v1/metrics", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range e.cfg.Headers {
+		req.Header.Set(name, string(value))
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "azure_metrics_exporter")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, recoverableError{err}
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		line, _ := bufio.NewReader(io.LimitReader(resp.Body, 512)).ReadString('\n')
+		err := fmt.Errorf("Server returned HTTP status %s: %s", resp.Status, line)
+		if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+			return nil, recoverableError{err}
+		}
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading body of response: %v", err)
+	}
+	var result ExportMetricsServiceResponse
+	if err := proto.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling response body: %v", err)
+	}
+	return &result, nil
+}
+
+// DecodeExportRequest decodes the body of an export request, as sent by an Exporter.
+func DecodeExportRequest(r io.Reader) (*ExportMetricsServiceRequest, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var req ExportMetricsServiceRequest
+	if err := proto.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling request: %v", err)
+	}
+	return &req, nil
+}
+
+func countDataPoints(req *ExportMetricsServiceRequest) int {
+	n := 0
+	for _, rm := range req.ResourceMetrics {
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				if m.Gauge != nil {
+					n += len(m.Gauge.DataPoints)
+				}
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,180 @@
+package otlp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/go-kit/kit/log"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// collector is an OpenTelemetry collector recording the received requests.
+type collector struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*ExportMetricsServiceRequest
+	headers  http.Header
+	// response is returned for every request. The next failures requests fail with failStatus.
+	response   *ExportMetricsServiceResponse
+	failures   int
+	failStatus int
+	attempts   int
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{response: &ExportMetricsServiceResponse{}}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.headers = r.Header
+		if r.URL.Path != "/v1/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		c.attempts++
+		if c.failures > 0 {
+			c.failures--
+			http.Error(w, "failing for tests", c.failStatus)
+			return
+		}
+		req, err := DecodeExportRequest(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.requests = append(c.requests, req)
+
+		body, err := proto.Marshal(c.response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(body)
+	}))
+	return c
+}
+
+func (c *collector) received() []*ExportMetricsServiceRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.requests
+}
+
+func testRequest(values ...float64) *ExportMetricsServiceRequest {
+	m := &Metric{Name: "test_metric", Gauge: &Gauge{}}
+	for i := range values {
+		m.Gauge.DataPoints = append(m.Gauge.DataPoints, &NumberDataPoint{
+			TimeUnixNano: uint64(i) * 1e9,
+			AsDouble:     &values[i],
+			Attributes:   []*KeyValue{StringAttribute("instance", "")},
+		})
+	}
+	return &ExportMetricsServiceRequest{ResourceMetrics: []*ResourceMetrics{{
+		Resource:     &Resource{Attributes: []*KeyValue{StringAttribute("cloud.provider", "azure")}},
+		ScopeMetrics: []*ScopeMetrics{{Scope: &InstrumentationScope{Name: "test"}, Metrics: []*Metric{m}}},
+	}}}
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func newTestExporter(endpoint string) *Exporter {
+	cfg := config.OTLPConfig{
+		Endpoint: endpoint,
+		Headers:  map[string]config.Secret{"Authorization": "Bearer token"},
+	}
+	return NewExporter(cfg, &http.Client{Transport: &http.Transport{}}, log.NewNopLogger())
+}
+
+func TestExport(t *testing.T) {
+	c := newCollector(t)
+	defer c.Close()
+	sent := counterValue(t, dataPointsSent)
+
+	e := newTestExporter(c.URL)
+	e.Start()
+	want := testRequest(0, 1.5)
+	e.Export(want)
+	e.Stop()
+
+	requests := c.received()
+	if len(requests) != 1 || !proto.Equal(requests[0], want) {
+		t.Fatalf("Expected request %v, got %v", want, requests)
+	}
+	// Zero values and empty strings must be sent, as they are members of oneofs.
+	p := requests[0].ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Gauge.DataPoints[0]
+	if p.AsDouble == nil || p.Attributes[0].Value.StringValue == nil {
+		t.Errorf("Zero value not sent: %v", p)
+	}
+	if got := c.headers.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Expected authorization header, got %q", got)
+	}
+	if got := counterValue(t, dataPointsSent) - sent; got != 2 {
+		t.Errorf("Expected 2 data points sent, got %v", got)
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	c := newCollector(t)
+	defer c.Close()
+	c.response = &ExportMetricsServiceResponse{PartialSuccess: &ExportMetricsPartialSuccess{RejectedDataPoints: 1, ErrorMessage: "invalid"}}
+	sent, failed := counterValue(t, dataPointsSent), counterValue(t, dataPointsFailed)
+
+	e := newTestExporter(c.URL)
+	e.Start()
+	e.Export(testRequest(1, 2, 3))
+	e.Stop()
+	if got := counterValue(t, dataPointsSent) - sent; got != 2 {
+		t.Errorf("Expected 2 data points sent, got %v", got)
+	}
+	if got := counterValue(t, dataPointsFailed) - failed; got != 1 {
+		t.Errorf("Expected 1 rejected data point, got %v", got)
+	}
+
+	e = newTestExporter(c.URL + "/unknown")
+	if _, err := e.post(nil); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected HTTP status error, got %v", err)
+	}
+}
+
+func TestExportRetries(t *testing.T) {
+	for _, tc := range []struct {
+		status   int
+		attempts int
+		sent     float64
+	}{
+		// Throttling and server errors are retried, other errors are not.
+		{status: http.StatusServiceUnavailable, attempts: 3, sent: 1},
+		{status: http.StatusTooManyRequests, attempts: 3, sent: 1},
+		{status: http.StatusBadRequest, attempts: 1},
+	} {
+		c := newCollector(t)
+		c.failures, c.failStatus = 2, tc.status
+		sent, failed := counterValue(t, dataPointsSent), counterValue(t, dataPointsFailed)
+
+		e := newTestExporter(c.URL)
+		e.send(testRequest(1), true)
+		if c.attempts != tc.attempts {
+			t.Errorf("%d: expected %d attempts, got %d", tc.status, tc.attempts, c.attempts)
+		}
+		if got := counterValue(t, dataPointsSent) - sent; got != tc.sent {
+			t.Errorf("%d: expected %v data points sent, got %v", tc.status, tc.sent, got)
+		}
+		if got := counterValue(t, dataPointsFailed) - failed; got != 1-tc.sent {
+			t.Errorf("%d: expected %v data points failed, got %v", tc.status, 1-tc.sent, got)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/azuretest"
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/RobustPerception/azure_metrics_exporter/otlp"
+)
+
+func TestOTLPExport(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []*otlp.ExportMetricsServiceRequest
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := otlp.DecodeExportRequest(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	defer setCollectionMode(collectionModeAsync)()
+	srv := setup(t, func(_ *azuretest.Server, c *config.Config) {
+		c.Targets[0].Aggregations = []string{"Total"}
+		c.ResourceHealth.Enabled = true
+		c.OTLP = &config.OTLPConfig{Endpoint: collector.URL}
+	}, webAppTarget("BytesReceived"))
+	defer srv.Close()
+
+	if err := oe.start(sc.C); err != nil {
+		t.Fatal(err)
+	}
+	runBackgroundQueries()
+	// Stopping the exporter sends the queued requests.
+	if err := oe.start(&config.Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 2 {
+		t.Fatalf("Expected a request per background query, got %d", len(requests))
+	}
+	wantAttributes := map[string]string{
+		"cloud.provider":       "azure",
+		"cloud.account.id":     azuretest.SubscriptionID,
+		"cloud.resource_id":    "/subscriptions/" + azuretest.SubscriptionID + azuretest.WebAppResource,
+		"azure.resource_group": "blog-group",
+	}
+	metrics := map[string]*otlp.Metric{}
+	for i, req := range requests {
+		if len(req.ResourceMetrics) != 1 {
+			t.Fatalf("Expected a single resource, got %v", req)
+		}
+		rm := req.ResourceMetrics[0]
+		attributes := otlpAttributes(rm.Resource.Attributes)
+		// The region is only known from metric responses.
+		if i == 1 {
+			wantAttributes["cloud.region"] = "westeurope"
+		}
+		if !reflect.DeepEqual(attributes, wantAttributes) {
+			t.Errorf("Expected resource attributes %v, got %v", wantAttributes, attributes)
+		}
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			metrics[m.Name] = m
+		}
+	}
+
+	m, ok := metrics["bytesreceived_bytes_total"]
+	if !ok || len(m.Gauge.DataPoints) != 1 {
+		t.Fatalf("Expected a gauge with a data point, got %v", metrics)
+	}
+	p := m.Gauge.DataPoints[0]
+	if *p.AsDouble != 2048 || time.Since(time.Unix(0, int64(p.TimeUnixNano))) > time.Minute {
+		t.Errorf("Unexpected data point %v", p)
+	}
+	if want := map[string]string{"resource_group": "blog-group", "resource_name": "blog"}; !reflect.DeepEqual(otlpAttributes(p.Attributes), want) {
+		t.Errorf("Expected data point attributes %v, got %v", want, otlpAttributes(p.Attributes))
+	}
+	if _, ok := metrics["azure_resource_health_status"]; !ok {
+		t.Errorf("Expected resource health status to be exported, got %v", metrics)
+	}
+}
+
+func otlpAttributes(attributes []*otlp.KeyValue) map[string]string {
+	m := map[string]string{}
+	for _, a := range attributes {
+		m[a.Key] = *a.Value.StringValue
+	}
+	return m
+}
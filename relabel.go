@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/prometheus/common/model"
+)
+
+// Relabel - Applies the relabel configs in order to the given labels.
+// The metric name is expected in the __name__ label. It returns nil if the metric is dropped.
+func Relabel(labels map[string]string, cfgs ...*config.RelabelConfig) map[string]string {
+	for _, cfg := range cfgs {
+		labels = relabel(labels, cfg)
+		if labels == nil {
+			return nil
+		}
+	}
+	return labels
+}
+
+func relabel(labels map[string]string, cfg *config.RelabelConfig) map[string]string {
+	values := make([]string, 0, len(cfg.SourceLabels))
+	for _, ln := range cfg.SourceLabels {
+		values = append(values, labels[ln])
+	}
+	val := strings.Join(values, cfg.Separator)
+
+	switch cfg.Action {
+	case config.RelabelDrop:
+		if cfg.Regex.MatchString(val) {
+			return nil
+		}
+	case config.RelabelKeep:
+		if !cfg.Regex.MatchString(val) {
+			return nil
+		}
+	case config.RelabelReplace:
+		indexes := cfg.Regex.FindStringSubmatchIndex(val)
+		// If there is no match no replacement must take place.
+		if indexes == nil {
+			break
+		}
+		target := model.LabelName(cfg.Regex.ExpandString([]byte{}, cfg.TargetLabel, val, indexes))
+		if !target.IsValid() {
+			delete(labels, cfg.TargetLabel)
+			break
+		}
+		res := cfg.Regex.ExpandString([]byte{}, cfg.Replacement, val, indexes)
+		if len(res) == 0 {
+			delete(labels, string(target))
+			break
+		}
+		labels[string(target)] = string(res)
+	case config.RelabelLabelMap:
+		out := make(map[string]string, len(labels))
+		// Take a copy to avoid infinite loops.
+		for ln, lv := range labels {
+			out[ln] = lv
+		}
+		for ln, lv := range labels {
+			if cfg.Regex.MatchString(ln) {
+				res := cfg.Regex.ReplaceAllString(ln, cfg.Replacement)
+				out[res] = lv
+			}
+		}
+		labels = out
+	case config.RelabelLabelDrop:
+		for ln := range labels {
+			if cfg.Regex.MatchString(ln) {
+				delete(labels, ln)
+			}
+		}
+	case config.RelabelLabelKeep:
+		for ln := range labels {
+			if !cfg.Regex.MatchString(ln) {
+				delete(labels, ln)
+			}
+		}
+	}
+	return labels
+}
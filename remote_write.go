@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/RobustPerception/azure_metrics_exporter/remotewrite"
+	"github.com/prometheus/common/model"
+)
+
+// remoteWriters holds the remote write queues of the current configuration.
+type remoteWriters struct {
+	mu     sync.Mutex
+	queues []*remotewrite.Queue
+}
+
+var rw = &remoteWriters{}
+
+// start replaces the remote write queues with the ones of the given configuration. The
+// samples left in the previous queues are pushed before.
+func (r *remoteWriters) start(c *config.Config) error {
+	queues := make([]*remotewrite.Queue, 0, len(c.RemoteWrite))
+	for _, cfg := range c.RemoteWrite {
+		client, err := newHTTPClient(cfg.HTTPClient)
+		if err != nil {
+			return fmt.Errorf("Error creating remote write HTTP client: %v", err)
+		}
+		queues = append(queues, remotewrite.NewQueue(*cfg, client, logger))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, q := range r.queues {
+		q.Stop()
+	}
+	r.queues = queues
+	for _, q := range queues {
+		q.Start()
+	}
+	return nil
+}
+
+// push queues the samples on all remote write queues. Samples without the timestamp of an
+// Azure data point are pushed with the given time.
+func (r *remoteWriters) push(c *config.Config, samples []sample, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queues) == 0 {
+		return
+	}
+	series := remoteWriteSeries(c, samples, now)
+	for _, q := range r.queues {
+		q.Append(series)
+	}
+}
+
+// remoteWriteSeries converts the samples to remote write series after relabeling them.
+func remoteWriteSeries(c *config.Config, samples []sample, now time.Time) []*remotewrite.TimeSeries {
+	series := make([]*remotewrite.TimeSeries, 0, len(samples))
+	for _, s := range samples {
+		name, lset, ok := relabelSample(c, s)
+		if !ok {
+			continue
+		}
+		labels := make([]*remotewrite.Label, 0, len(lset)+1)
+		labels = append(labels, &remotewrite.Label{Name: model.MetricNameLabel, Value: name})
+		for ln, lv := range lset {
+			labels = append(labels, &remotewrite.Label{Name: ln, Value: lv})
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+		timestamp := s.timestamp
+		if timestamp.IsZero() {
+			timestamp = now
+		}
+		series = append(series, &remotewrite.TimeSeries{
+			Labels:  labels,
+			Samples: []*remotewrite.Sample{{Value: s.value, Timestamp: timestamp.UnixNano() / int64(time.Millisecond)}},
+		})
+	}
+	return series
+}
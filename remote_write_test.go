@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/azuretest"
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/RobustPerception/azure_metrics_exporter/remotewrite"
+	"github.com/prometheus/common/model"
+)
+
+func TestRemoteWrite(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		series []*remotewrite.TimeSeries
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := remotewrite.DecodeWriteRequest(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		series = append(series, req.Timeseries...)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	defer setCollectionMode(collectionModeAsync)()
+	srv := setup(t, func(_ *azuretest.Server, c *config.Config) {
+		c.Targets[0].Aggregations = []string{"Total"}
+		c.Targets[0].Labels = map[string]string{"env": "test"}
+		c.Backfill.Enabled = true
+		c.RemoteWrite = []*config.RemoteWriteConfig{{URL: receiver.URL, BatchSendDeadline: model.Duration(10 * time.Millisecond)}}
+	}, webAppTarget("BytesReceived"))
+	defer srv.Close()
+
+	if err := rw.start(sc.C); err != nil {
+		t.Fatal(err)
+	}
+	runBackgroundQueries()
+	// Stopping the queues pushes the remaining samples.
+	if err := rw.start(&config.Config{}); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(series) != 2 {
+		t.Fatalf("Expected a series per data point, got %v", series)
+	}
+	for i, ts := range []time.Time{azuretest.FixtureTime.Add(-time.Minute), azuretest.FixtureTime} {
+		s := series[i]
+		labels := map[string]string{}
+		for j, l := range s.Labels {
+			if j > 0 && s.Labels[j-1].Name >= l.Name {
+				t.Errorf("Labels not sorted: %v", s.Labels)
+			}
+			labels[l.Name] = l.Value
+		}
+		if labels["__name__"] != "bytesreceived_bytes_total" || labels["env"] != "test" || labels["resource_name"] != "blog" || len(labels) != 4 {
+			t.Errorf("Unexpected labels %v", labels)
+		}
+		if len(s.Samples) != 1 || s.Samples[0].Timestamp != ts.UnixNano()/int64(time.Millisecond) {
+			t.Errorf("Expected a sample with the Azure timestamp %v, got %v", ts, s.Samples)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Package remotewrite pushes samples to Prometheus remote write endpoints.
+package remotewrite
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	samplesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "azure_exporter_remote_write_samples_sent_total",
+		Help: "Samples pushed to the remote write endpoint.",
+	}, []string{"url"})
+	samplesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "azure_exporter_remote_write_samples_failed_total",
+		Help: "Samples that could not be pushed to the remote write endpoint, after retrying.",
+	}, []string{"url"})
+	samplesRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "azure_exporter_remote_write_samples_rejected_total",
+		Help: "Samples rejected by the remote write endpoint with a client error.",
+	}, []string{"url"})
+	samplesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "azure_exporter_remote_write_samples_dropped_total",
+		Help: "Samples dropped because the remote write queue was full.",
+	}, []string{"url"})
+)
+
+// Collect sends the metrics about the samples pushed by all queues.
+func Collect(ch chan<- prometheus.Metric) {
+	samplesSent.Collect(ch)
+	samplesFailed.Collect(ch)
+	samplesRejected.Collect(ch)
+	samplesDropped.Collect(ch)
+}
+
+// recoverableError is a failure of a request that may succeed when retried.
+type recoverableError struct {
+	error
+}
+
+// Queue buffers samples and pushes them to a remote write endpoint in batches.
+type Queue struct {
+	cfg    config.RemoteWriteConfig
+	client *http.Client
+	logger log.Logger
+	// name identifies the endpoint in logs and metrics, without credentials.
+	name string
+
+	series chan *TimeSeries
+	stop   chan struct{}
+	done   chan struct{}
+
+	// last holds the timestamp of the last sample queued per series, as samples collected
+	// again, e.g. carried over from a previous collection, must not be pushed twice.
+	mu   sync.Mutex
+	last map[string]int64
+}
+
+// NewQueue returns a queue pushing to the endpoint of the given configuration with the
+// given client. It must be started to push samples.
+func NewQueue(cfg config.RemoteWriteConfig, client *http.Client, logger log.Logger) *Queue {
+	name := cfg.URL
+	if u, err := url.Parse(cfg.URL); err == nil {
+		u.User = nil
+		name = u.String()
+	}
+	return &Queue{
+		cfg:    cfg,
+		client: client,
+		logger: log.With(logger, "url", name),
+		name:   name,
+		series: make(chan *TimeSeries, cfg.QueueCapacity),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		last:   map[string]int64{},
+	}
+}
+
+// Start starts pushing the queued samples.
+func (q *Queue) Start() {
+	go q.run()
+}
+
+// Stop pushes the samples left in the queue once, without retrying, and stops the queue.
+func (q *Queue) Stop() {
+	close(q.stop)
+	<-q.done
+}
+
+// Append queues the series, which must have a single sample each. Samples not newer than
+// the last one queued for their series were already pushed, and are skipped. If the queue
+// is full, the samples are dropped.
+func (q *Queue) Append(series []*TimeSeries) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dropped := 0
+	for _, s := range series {
+		key := seriesKey(s.Labels)
+		if last, ok := q.last[key]; ok && s.Samples[0].Timestamp <= last {
+			continue
+		}
+		select {
+		case q.series <- s:
+			q.last[key] = s.Samples[0].Timestamp
+		default:
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		samplesDropped.WithLabelValues(q.name).Add(float64(dropped))
+		level.Warn(q.logger).Log("msg", "Remote write queue is full, dropping samples", "count", dropped)
+	}
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+	ticker := time.NewTicker(time.Duration(q.cfg.BatchSendDeadline))
+	defer ticker.Stop()
+
+	batch := make([]*TimeSeries, 0, q.cfg.MaxSamplesPerSend)
+	for {
+		select {
+		case <-q.stop:
+			for {
+				select {
+				case s := <-q.series:
+					batch = append(batch, s)
+					if len(batch) < q.cfg.MaxSamplesPerSend {
+						continue
+					}
+				default:
+				}
+				if len(batch) == 0 {
+					return
+				}
+				q.send(batch, false)
+				batch = batch[:0]
+			}
+		case s := <-q.series:
+			batch = append(batch, s)
+			if len(batch) >= q.cfg.MaxSamplesPerSend {
+				q.send(batch, true)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				q.send(batch, true)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// send pushes the batch, retrying recoverable errors with exponential backoff if retry is set.
+func (q *Queue) send(batch []*TimeSeries, retry bool) {
+	body, err := encodeWriteRequest(batch)
+	if err != nil {
+		samplesFailed.WithLabelValues(q.name).Add(float64(len(batch)))
+		level.Error(q.logger).Log("msg", "Failed to encode remote write request", "err", err)
+		return
+	}
+
+	backoff := time.Duration(q.cfg.MinBackoff)
+	for attempt := 0; ; attempt++ {
+		err := q.post(body)
+		if err == nil {
+			samplesSent.WithLabelValues(q.name).Add(float64(len(batch)))
+			return
+		}
+		if _, ok := err.(recoverableError); !ok {
+			samplesRejected.WithLabelValues(q.name).Add(float64(len(batch)))
+			level.Error(q.logger).Log("msg", "Remote write endpoint rejected samples", "count", len(batch), "err", err)
+			return
+		}
+		if !retry || attempt >= *q.cfg.MaxRetries {
+			samplesFailed.WithLabelValues(q.name).Add(float64(len(batch)))
+			level.Error(q.logger).Log("msg", "Failed to push samples", "count", len(batch), "err", err)
+			return
+		}
+		level.Warn(q.logger).Log("msg", "Failed to push samples, retrying", "backoff", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-q.stop:
+			// Give the batch a last try without waiting for the backoff.
+			retry = false
+		}
+		backoff *= 2
+		if max := time.Duration(q.cfg.MaxBackoff); backoff > max {
+			backoff = max
+		}
+	}
+}
+
+// post sends a single remote write request. Network errors, throttling and server errors are
+// recoverable.
+func (q *Queue) post(body []byte) error {
+	req, err := http.NewRequest("POST", q.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range q.cfg.Headers {
+		req.Header.Set(name, string(value))
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "azure_metrics_exporter")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return recoverableError{err}
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	line, _ := bufio.NewReader(io.LimitReader(resp.Body, 512)).ReadString('\n')
+	err = fmt.Errorf("Server returned HTTP status %s: %s", resp.Status, line)
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return recoverableError{err}
+	}
+	return err
+}
+
+// seriesKey identifies the series of the labels.
+func seriesKey(labels []*Label) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(l.Name)
+		b.WriteByte(0xff)
+		b.WriteString(l.Value)
+		b.WriteByte(0xff)
+	}
+	return b.String()
+}
@@ -0,0 +1,229 @@
+package remotewrite
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// receiver is a remote write endpoint recording the received series.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests int
+	series   []*TimeSeries
+	headers  http.Header
+}
+
+// newReceiver starts a receiver responding with the given statuses in turn, and 204 after them.
+func newReceiver(statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests++
+		r.headers = req.Header
+		if len(r.statuses) > 0 {
+			status := r.statuses[0]
+			r.statuses = r.statuses[1:]
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		wr, err := DecodeWriteRequest(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.series = append(r.series, wr.Timeseries...)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return r
+}
+
+func (r *receiver) received() ([]*TimeSeries, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.series, r.requests
+}
+
+func testConfig(url string) config.RemoteWriteConfig {
+	maxRetries := 2
+	return config.RemoteWriteConfig{
+		URL:               url,
+		Headers:           map[string]config.Secret{"Authorization": "Bearer token"},
+		QueueCapacity:     10,
+		MaxSamplesPerSend: 2,
+		BatchSendDeadline: model.Duration(10 * time.Millisecond),
+		MaxRetries:        &maxRetries,
+		MinBackoff:        model.Duration(time.Millisecond),
+		MaxBackoff:        model.Duration(2 * time.Millisecond),
+	}
+}
+
+func testSeries(values ...float64) []*TimeSeries {
+	series := []*TimeSeries{}
+	for i, v := range values {
+		series = append(series, &TimeSeries{
+			Labels:  []*Label{{Name: "__name__", Value: "test_metric"}, {Name: "i", Value: string(rune('a' + i))}},
+			Samples: []*Sample{{Value: v, Timestamp: 1000 * int64(i)}},
+		})
+	}
+	return series
+}
+
+func counterValue(t *testing.T, c *prometheus.CounterVec, url string) float64 {
+	var m dto.Metric
+	if err := c.WithLabelValues(url).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// waitForCounter waits until the counter of the url reaches the given value.
+func waitForCounter(t *testing.T, c *prometheus.CounterVec, url string, value float64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for counterValue(t, c, url) < value && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitFor waits until the receiver got the given number of series.
+func waitFor(t *testing.T, r *receiver, n int) []*TimeSeries {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if series, _ := r.received(); len(series) >= n {
+			return series
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	series, _ := r.received()
+	t.Fatalf("Expected %d series to be received, got %d", n, len(series))
+	return nil
+}
+
+func TestQueueRetries(t *testing.T) {
+	r := newReceiver(http.StatusInternalServerError, http.StatusTooManyRequests)
+	defer r.Close()
+
+	q := NewQueue(testConfig(r.URL), http.DefaultClient, log.NewNopLogger())
+	q.Start()
+	defer q.Stop()
+	q.Append(testSeries(1, 2, 3))
+
+	series := waitFor(t, r, 3)
+	for i, s := range series {
+		if s.Samples[0].Value != float64(i+1) || s.Samples[0].Timestamp != 1000*int64(i) || s.Labels[0].Value != "test_metric" {
+			t.Errorf("Unexpected series %v", s)
+		}
+	}
+	if _, requests := r.received(); requests != 4 {
+		t.Errorf("Expected 2 failed and 2 successful requests, got %d", requests)
+	}
+	for name, want := range map[string]string{
+		"Authorization":                     "Bearer token",
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	} {
+		if got := r.headers.Get(name); got != want {
+			t.Errorf("Expected header %s: %s, got %q", name, want, got)
+		}
+	}
+	// The counter is only updated once the last request completed.
+	waitForCounter(t, samplesSent, r.URL, 3)
+	if got := counterValue(t, samplesSent, r.URL); got != 3 {
+		t.Errorf("Expected 3 samples sent, got %v", got)
+	}
+}
+
+func TestQueueErrors(t *testing.T) {
+	// Client errors aren't retried, server errors are retried max_retries times.
+	r := newReceiver(http.StatusBadRequest, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	defer r.Close()
+
+	q := NewQueue(testConfig(r.URL), http.DefaultClient, log.NewNopLogger())
+	q.Start()
+	defer q.Stop()
+	q.Append(testSeries(1, 2, 3, 4))
+
+	waitForCounter(t, samplesFailed, r.URL, 2)
+	if series, requests := r.received(); len(series) != 0 || requests != 4 {
+		t.Errorf("Expected 4 failed requests, got %d requests and %d series", requests, len(series))
+	}
+	if got := counterValue(t, samplesRejected, r.URL); got != 2 {
+		t.Errorf("Expected 2 rejected samples, got %v", got)
+	}
+	if got := counterValue(t, samplesFailed, r.URL); got != 2 {
+		t.Errorf("Expected 2 failed samples, got %v", got)
+	}
+}
+
+func TestQueueNoRetries(t *testing.T) {
+	r := newReceiver(http.StatusBadGateway)
+	defer r.Close()
+
+	cfg := testConfig(r.URL)
+	maxRetries := 0
+	cfg.MaxRetries = &maxRetries
+	q := NewQueue(cfg, http.DefaultClient, log.NewNopLogger())
+	q.Start()
+	defer q.Stop()
+	q.Append(testSeries(1, 2))
+
+	waitForCounter(t, samplesFailed, r.URL, 2)
+	if _, requests := r.received(); requests != 1 {
+		t.Errorf("Expected a single request, got %d", requests)
+	}
+}
+
+func TestQueueDuplicates(t *testing.T) {
+	r := newReceiver()
+	defer r.Close()
+
+	q := NewQueue(testConfig(r.URL), http.DefaultClient, log.NewNopLogger())
+	q.Start()
+	defer q.Stop()
+	q.Append(testSeries(1, 2))
+	waitFor(t, r, 2)
+
+	// Samples already pushed are skipped, newer ones of the same series are pushed.
+	q.Append(testSeries(1, 2))
+	newer := testSeries(3)
+	newer[0].Samples[0].Timestamp = 500
+	q.Append(newer)
+	waitFor(t, r, 3)
+	time.Sleep(50 * time.Millisecond)
+	if series, _ := r.received(); len(series) != 3 || series[2].Samples[0].Value != 3 {
+		t.Errorf("Expected only the newer sample to be pushed again, got %v", series)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	r := newReceiver()
+	defer r.Close()
+
+	cfg := testConfig(r.URL)
+	cfg.QueueCapacity = 3
+	q := NewQueue(cfg, http.DefaultClient, log.NewNopLogger())
+	q.Append(testSeries(1, 2, 3, 4, 5))
+	if got := counterValue(t, samplesDropped, r.URL); got != 2 {
+		t.Errorf("Expected 2 dropped samples, got %v", got)
+	}
+
+	// Stopping pushes the queued samples.
+	q.Start()
+	q.Stop()
+	if series, _ := r.received(); len(series) != 3 {
+		t.Errorf("Expected the 3 queued samples to be pushed on stop, got %d", len(series))
+	}
+}
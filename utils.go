@@ -3,41 +3,155 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/go-kit/kit/log/level"
 )
 
 // PrintPrettyJSON - Prints structs nicely for debugging.
 func PrintPrettyJSON(input map[string]interface{}) {
 	out, err := json.MarshalIndent(input, "", "\t")
 	if err != nil {
-		log.Fatalf("Error indenting JSON: %v", err)
+		level.Error(logger).Log("msg", "Error indenting JSON", "err", err)
+		os.Exit(1)
 	}
 	fmt.Println(string(out))
 }
 
+// queryDelay is how far behind the current time metrics are queried, to give Azure time to
+// ingest the latest data.
+const queryDelay = 3 * time.Minute
+
 // GetTimes - Returns the endTime and startTime used for querying Azure Metrics API
 func GetTimes() (string, string) {
 	// Make sure we are using UTC
 	now := time.Now().UTC()
 
-	// Use query delay of 3 minutes when querying for latest metric data
-	endTime := now.Add(time.Minute * time.Duration(-3)).Format(time.RFC3339)
-	startTime := now.Add(time.Minute * time.Duration(-4)).Format(time.RFC3339)
+	endTime := now.Add(-queryDelay).Format(time.RFC3339)
+	startTime := now.Add(-queryDelay - time.Minute).Format(time.RFC3339)
 	return endTime, startTime
 }
 
+// CreateMetricName - Returns a metric name conforming to Prometheus metric name conventions
+// for the given Azure metric. Metrics in an explicitly configured namespace are prefixed with it.
+func CreateMetricName(namespace, name, unit string) string {
+	metricName := strings.Replace(name, " ", "_", -1)
+	metricName = strings.ToLower(metricName + "_" + unit)
+	metricName = strings.Replace(metricName, "/", "_per_", -1)
+	if namespace != "" {
+		// Unlike slashes in the metric name, which become "_per_", slashes in the namespace are
+		// replaced with "_" like other invalid characters.
+		metricName = strings.ToLower(namespace) + "_" + metricName
+	}
+	return invalidMetricChars.ReplaceAllString(metricName, "_")
+}
+
+// prometheusUnits are the Prometheus base units of Azure metric units, keyed by the lower case
+// Azure unit, and the factors converting values to them. Counts have no unit.
+var prometheusUnits = map[string]struct {
+	unit  string
+	scale float64
+}{
+	"count":          {"", 1},
+	"unspecified":    {"", 1},
+	"countpersecond": {"per_second", 1},
+	"bytes":          {"bytes", 1},
+	"bytespersecond": {"bytes_per_second", 1},
+	"bitspersecond":  {"bytes_per_second", 1.0 / 8},
+	"byteseconds":    {"byte_seconds", 1},
+	"seconds":        {"seconds", 1},
+	"milliseconds":   {"seconds", 1e-3},
+	"percent":        {"ratio", 1e-2},
+	"cores":          {"cores", 1},
+	"millicores":     {"cores", 1e-3},
+	"nanocores":      {"cores", 1e-9},
+}
+
+// NormalizeUnit - Returns the Prometheus base unit of an Azure metric unit and the factor
+// converting values to it. Unknown units are kept, lower cased, with a factor of 1.
+func NormalizeUnit(unit string) (string, float64) {
+	if u, ok := prometheusUnits[strings.ToLower(unit)]; ok {
+		return u.unit, u.scale
+	}
+	return invalidMetricChars.ReplaceAllString(strings.ToLower(unit), "_"), 1
+}
+
+// CreateNormalizedMetricName - Returns a metric name following the Prometheus naming
+// conventions for an Azure metric with a unit returned by NormalizeUnit. The unit is
+// appended unless the name already ends with it, e.g. memory_available_bytes.
+func CreateNormalizedMetricName(namespace, name, unit string) string {
+	metricName := strings.ToLower(strings.Replace(name, "/", "_per_", -1))
+	if namespace != "" {
+		metricName = strings.ToLower(namespace) + "_" + metricName
+	}
+	metricName = invalidMetricChars.ReplaceAllString(metricName, "_")
+	if unit != "" && !strings.HasSuffix(metricName, "_"+unit) {
+		metricName += "_" + unit
+	}
+	return metricName
+}
+
+// GetMetricsByNamespace - Returns the metrics of a target grouped by metric namespace.
+func GetMetricsByNamespace(t config.Target) map[string][]config.Metric {
+	metrics := make(map[string][]config.Metric)
+	for _, metric := range t.Metrics {
+		namespace := t.MetricNamespace
+		if metric.MetricNamespace != "" {
+			namespace = metric.MetricNamespace
+		}
+		metrics[namespace] = append(metrics[namespace], metric)
+	}
+	return metrics
+}
+
+// metricNames returns the comma separated names of the metrics, as used in metric queries.
+func metricNames(metrics []config.Metric) string {
+	names := make([]string, len(metrics))
+	for i, m := range metrics {
+		names[i] = m.Name
+	}
+	return strings.Join(names, ",")
+}
+
 // CreateResourceLabels - Returns resource labels for a give resource ID.
 func CreateResourceLabels(resourceID string) map[string]string {
-	labels := make(map[string]string)
-	labels["resource_group"] = strings.Split(resourceID, "/")[4]
-	labels["resource_name"] = strings.Split(resourceID, "/")[8]
+	// Labels of segments missing from malformed IDs are left empty.
+	labels := map[string]string{"resource_group": "", "resource_name": ""}
+	parts := strings.Split(resourceID, "/")
+	if len(parts) > 4 {
+		labels["resource_group"] = parts[4]
+	}
+	if len(parts) > 8 {
+		labels["resource_name"] = parts[8]
+	}
+	return labels
+}
+
+// AddStaticLabels - Adds configured static labels to the given labels, later sets take precedence.
+func AddStaticLabels(labels map[string]string, static ...map[string]string) map[string]string {
+	for _, set := range static {
+		for k, v := range set {
+			labels[k] = v
+		}
+	}
 	return labels
 }
 
+// TargetStaticLabels - Returns the static labels of the target. Label names only set on other
+// targets are added with an empty value, so all series of a metric have the same label names.
+func TargetStaticLabels(c *config.Config, target config.Target) map[string]string {
+	labels := make(map[string]string)
+	for _, t := range c.Targets {
+		for k := range t.Labels {
+			labels[k] = ""
+		}
+	}
+	return AddStaticLabels(labels, c.Labels, target.Labels)
+}
+
 func hasAggregation(t config.Target, aggregation string) bool {
 	// Serve all aggregations when none is specified in the config
 	if len(t.Aggregations) == 0 {
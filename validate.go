@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/go-kit/kit/log/level"
+)
+
+// validateMetrics checks the configured metrics of every target against the metric
+// definitions of its resource. Depending on the validation mode of the configuration,
+// problems are logged, returned as an error, or the offending metrics are dropped.
+func (ac *AzureClient) validateMetrics(c *config.Config) error {
+	if c.ValidationMode == config.ValidationNone || len(c.Targets) == 0 {
+		return nil
+	}
+
+	// Use a client for the new configuration, the current one may not be able to reach Azure.
+	client, err := newHTTPClient(c.HTTPClient)
+	if err != nil {
+		return err
+	}
+	vc := &AzureClient{client: client}
+
+	// Access tokens by credentials profile.
+	tokens := make(map[string]string)
+	problems := []string{}
+	targets := []config.Target{}
+	for _, target := range c.Targets {
+		token, ok := tokens[target.Credentials]
+		if !ok {
+			token, _, err = vc.requestAccessToken(c.Endpoints, c.TargetCredentials(target), c.Endpoints.ResourceManager)
+			if err != nil {
+				return fmt.Errorf("Failed to get token for metric validation of target %s: %v", target.Resource, err)
+			}
+			tokens[target.Credentials] = token
+		}
+
+		// Metric definitions of the target by metric namespace and lower case metric name.
+		definitions := make(map[string]map[string]metricDefinitionResponse)
+		skipped := false
+		for namespace := range GetMetricsByNamespace(target) {
+			defs, err := vc.getTargetMetricDefinitions(c, target, namespace, token)
+			if err != nil {
+				if c.ValidationMode == config.ValidationFail {
+					return fmt.Errorf("Failed to fetch metric definitions for target %s: %v", target.Resource, err)
+				}
+				level.Warn(logger).Log(append([]interface{}{"msg", "Skipping validation of target, failed to fetch metric definitions", "target", target.Resource}, errorFields(err)...)...)
+				skipped = true
+				break
+			}
+			definitions[namespace] = make(map[string]metricDefinitionResponse)
+			for _, def := range defs.MetricDefinitionResponses {
+				definitions[namespace][strings.ToLower(def.Name.Value)] = def
+			}
+		}
+		if skipped {
+			targets = append(targets, target)
+			continue
+		}
+
+		metrics := []config.Metric{}
+		for _, metric := range target.Metrics {
+			namespace := target.MetricNamespace
+			if metric.MetricNamespace != "" {
+				namespace = metric.MetricNamespace
+			}
+			invalid := false
+			def, ok := definitions[namespace][strings.ToLower(metric.Name)]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("target %s: unknown metric %q", target.Resource, metric.Name))
+				invalid = true
+			case def.IsDimensionRequired && len(metric.Dimensions) == 0:
+				problems = append(problems, fmt.Sprintf("target %s: metric %q requires a dimension", target.Resource, metric.Name))
+				invalid = true
+			case len(unknownDimensions(metric, def)) > 0:
+				problems = append(problems, fmt.Sprintf("target %s: metric %q has no dimensions %v", target.Resource, metric.Name, unknownDimensions(metric, def)))
+				invalid = true
+			default:
+				if unsupported := unsupportedAggregations(target, def); len(unsupported) > 0 {
+					problems = append(problems, fmt.Sprintf("target %s: metric %q does not support aggregations %v (supported: %v)",
+						target.Resource, metric.Name, unsupported, def.SupportedAggregationTypes))
+				}
+			}
+			if invalid && c.ValidationMode == config.ValidationDrop {
+				level.Warn(logger).Log("msg", "Dropping invalid metric", "target", target.Resource, "metric", metric.Name)
+				continue
+			}
+			metrics = append(metrics, metric)
+		}
+
+		if len(metrics) == 0 && len(target.Metrics) > 0 {
+			level.Warn(logger).Log("msg", "Dropping target, none of its metrics are valid", "target", target.Resource)
+			continue
+		}
+		target.Metrics = metrics
+		targets = append(targets, target)
+	}
+
+	if len(problems) > 0 && c.ValidationMode == config.ValidationFail {
+		return fmt.Errorf("invalid metrics: %s", strings.Join(problems, "; "))
+	}
+	for _, p := range problems {
+		level.Warn(logger).Log("msg", "Metric validation", "problem", p)
+	}
+	c.Targets = targets
+	return nil
+}
+
+// unknownDimensions returns the dimensions the metric is split by that aren't in its definition.
+func unknownDimensions(m config.Metric, def metricDefinitionResponse) []string {
+	unknown := []string{}
+	for _, d := range m.Dimensions {
+		ok := false
+		for _, dim := range def.Dimensions {
+			if strings.EqualFold(d, dim.Value) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			unknown = append(unknown, d)
+		}
+	}
+	return unknown
+}
+
+// unsupportedAggregations returns the explicitly configured aggregations of the target
+// that the metric definition doesn't support.
+func unsupportedAggregations(t config.Target, def metricDefinitionResponse) []string {
+	// Older metric definitions don't list the supported aggregations.
+	if len(def.SupportedAggregationTypes) == 0 {
+		return nil
+	}
+
+	unsupported := []string{}
+	for _, aggr := range t.Aggregations {
+		ok := false
+		for _, supported := range def.SupportedAggregationTypes {
+			if strings.EqualFold(aggr, supported) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			unsupported = append(unsupported, aggr)
+		}
+	}
+	return unsupported
+}
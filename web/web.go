@@ -0,0 +1,247 @@
+// Package web serves the exporter's HTTP endpoints with optional TLS and basic
+// authentication, configured in a web configuration file compatible with the
+// Prometheus exporter-toolkit. The file is read again on every TLS handshake and
+// request, so changes are applied without a restart.
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"golang.org/x/crypto/bcrypt"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config - web configuration.
+type Config struct {
+	TLSConfig TLSServerConfig          `yaml:"tls_server_config"`
+	Users     map[string]config.Secret `yaml:"basic_auth_users"`
+}
+
+// TLSServerConfig - TLS configuration of the web server.
+type TLSServerConfig struct {
+	CertFile   string     `yaml:"cert_file"`
+	KeyFile    string     `yaml:"key_file"`
+	ClientAuth string     `yaml:"client_auth_type"`
+	ClientCAs  string     `yaml:"client_ca_file"`
+	MinVersion tlsVersion `yaml:"min_version"`
+	MaxVersion tlsVersion `yaml:"max_version"`
+}
+
+type tlsVersion uint16
+
+var tlsVersions = map[string]tlsVersion{
+	"TLS13": tls.VersionTLS13,
+	"TLS12": tls.VersionTLS12,
+	"TLS11": tls.VersionTLS11,
+	"TLS10": tls.VersionTLS10,
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (tv *tlsVersion) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	v, ok := tlsVersions[s]
+	if !ok {
+		return fmt.Errorf("Unknown TLS version: %s", s)
+	}
+	*tv = v
+	return nil
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// getConfig reads and parses the web configuration file.
+func getConfig(configPath string) (*Config, error) {
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading web config file: %s", err)
+	}
+	c := &Config{
+		TLSConfig: TLSServerConfig{
+			MinVersion: tls.VersionTLS12,
+			MaxVersion: tls.VersionTLS13,
+		},
+	}
+	if err := yaml.UnmarshalStrict(content, c); err != nil {
+		return nil, fmt.Errorf("Error parsing web config file: %s", err)
+	}
+	return c, nil
+}
+
+// newTLSConfig creates a TLS configuration for the server from the given configuration.
+func newTLSConfig(c *TLSServerConfig) (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, fmt.Errorf("Both cert_file and key_file must be set in tls_server_config")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load server certificate %s and key %s: %v", c.CertFile, c.KeyFile, err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   uint16(c.MinVersion),
+		MaxVersion:   uint16(c.MaxVersion),
+	}
+
+	if c.ClientCAs != "" {
+		clientCAs, err := ioutil.ReadFile(c.ClientCAs)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read client CA file %s: %v", c.ClientCAs, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(clientCAs) {
+			return nil, fmt.Errorf("Unable to use client CA file %s: no certificates found", c.ClientCAs)
+		}
+		cfg.ClientCAs = pool
+	}
+
+	if c.ClientAuth != "" {
+		clientAuth, ok := clientAuthTypes[c.ClientAuth]
+		if !ok {
+			return nil, fmt.Errorf("Invalid client_auth_type: %s", c.ClientAuth)
+		}
+		cfg.ClientAuth = clientAuth
+	}
+	if c.ClientCAs != "" && cfg.ClientAuth == tls.NoClientCert {
+		return nil, fmt.Errorf("client_ca_file is set but client_auth_type is NoClientCert")
+	}
+	if c.ClientCAs == "" && (cfg.ClientAuth == tls.VerifyClientCertIfGiven || cfg.ClientAuth == tls.RequireAndVerifyClientCert) {
+		return nil, fmt.Errorf("client_auth_type %s requires client_ca_file", c.ClientAuth)
+	}
+	return cfg, nil
+}
+
+// ListenAndServe starts the server, using TLS and basic authentication if configured in
+// the web configuration file. A plain HTTP server is started if configPath is empty.
+func ListenAndServe(server *http.Server, configPath string, logger log.Logger) error {
+	if configPath == "" {
+		return server.ListenAndServe()
+	}
+
+	// Check the configuration once before serving, so errors are reported at startup.
+	c, err := getConfig(configPath)
+	if err != nil {
+		return err
+	}
+	for user, hash := range c.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("Invalid bcrypt hash for user %s: %s", user, err)
+		}
+	}
+
+	handler := server.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	server.Handler = &authHandler{
+		configPath: configPath,
+		handler:    handler,
+		logger:     logger,
+		cache:      make(map[string]bool),
+	}
+
+	if c.TLSConfig.CertFile == "" && c.TLSConfig.KeyFile == "" {
+		return server.ListenAndServe()
+	}
+	if _, err := newTLSConfig(&c.TLSConfig); err != nil {
+		return err
+	}
+	server.TLSConfig = &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c, err := getConfig(configPath)
+			if err != nil {
+				level.Error(logger).Log("msg", "Error loading web config file", "err", err)
+				return nil, err
+			}
+			cfg, err := newTLSConfig(&c.TLSConfig)
+			if err != nil {
+				level.Error(logger).Log("msg", "Error creating TLS config", "err", err)
+			}
+			return cfg, err
+		},
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// authHandler requires basic authentication when users are configured.
+type authHandler struct {
+	configPath string
+	handler    http.Handler
+	logger     log.Logger
+
+	mu sync.Mutex
+	// cache holds the results of bcrypt comparisons, which are expensive on purpose.
+	cache map[string]bool
+}
+
+// dummyHash is compared against for unknown users, so they take as long as known ones.
+const dummyHash = "$2y$10$QOauhQNbBCuQDKes6eFzPeMqBSjb7Mr5DUmpZ/VcEd00UAV/LDeSi"
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, err := getConfig(h.configPath)
+	if err != nil {
+		level.Error(h.logger).Log("msg", "Unable to parse web config file", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if len(c.Users) == 0 {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+
+	user, pass, ok := r.BasicAuth()
+	if ok {
+		hash, validUser := c.Users[user]
+		if !validUser {
+			hash = dummyHash
+		}
+		if h.checkPassword(user, string(hash), pass) && validUser {
+			h.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	w.Header().Set("WWW-Authenticate", "Basic")
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func (h *authHandler) checkPassword(user, hash, pass string) bool {
+	sum := sha256.Sum256([]byte(user + ":" + hash + ":" + pass))
+	key := hex.EncodeToString(sum[:])
+
+	h.mu.Lock()
+	ok, found := h.cache[key]
+	h.mu.Unlock()
+	if found {
+		return ok
+	}
+
+	ok = bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+	h.mu.Lock()
+	// Keep the cache bounded, entries are cheap to recompute.
+	if len(h.cache) > 100 {
+		h.cache = make(map[string]bool)
+	}
+	h.cache[key] = ok
+	h.mu.Unlock()
+	return ok
+}
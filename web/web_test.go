@@ -0,0 +1,184 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hash(t *testing.T, password string) string {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(h)
+}
+
+func TestBasicAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "web.yml")
+	writeFile(t, configPath, fmt.Sprintf("basic_auth_users:\n  alice: %s\n", hash(t, "secret")))
+
+	h := &authHandler{
+		configPath: configPath,
+		handler:    http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		logger:     log.NewNopLogger(),
+		cache:      make(map[string]bool),
+	}
+	check := func(user, pass string, want int) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s:%s: expected status %d, got %d", user, pass, want, rec.Code)
+		}
+	}
+
+	check("", "", http.StatusUnauthorized)
+	check("alice", "wrong", http.StatusUnauthorized)
+	check("bob", "secret", http.StatusUnauthorized)
+	check("alice", "secret", http.StatusOK)
+	check("alice", "secret", http.StatusOK)
+
+	// Changes to the file apply to the next request.
+	writeFile(t, configPath, fmt.Sprintf("basic_auth_users:\n  bob: %s\n", hash(t, "other")))
+	check("alice", "secret", http.StatusUnauthorized)
+	check("bob", "other", http.StatusOK)
+
+	writeFile(t, configPath, "tls_server_config: {}\n")
+	check("", "", http.StatusOK)
+
+	writeFile(t, configPath, "unknown: true\n")
+	check("", "", http.StatusInternalServerError)
+}
+
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	writeFile(t, certFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeFile(t, keyFile, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})))
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCertificate(t, dir)
+	configPath := filepath.Join(dir, "web.yml")
+
+	files := strings.NewReplacer("CERT", certFile, "KEY", keyFile)
+	for _, tc := range []struct {
+		config string
+		err    string
+	}{
+		{config: "tls_server_config:\n  cert_file: CERT\n  key_file: KEY\n  min_version: TLS13\n"},
+		{config: "tls_server_config:\n  cert_file: CERT\n", err: "Both cert_file and key_file must be set"},
+		{config: "tls_server_config:\n  cert_file: CERT\n  key_file: KEY\n  min_version: SSL3\n", err: "Unknown TLS version: SSL3"},
+		{config: "tls_server_config:\n  cert_file: CERT\n  key_file: KEY\n  client_auth_type: Always\n", err: "Invalid client_auth_type: Always"},
+		{config: "tls_server_config:\n  cert_file: CERT\n  key_file: KEY\n  client_ca_file: CERT\n", err: "client_auth_type is NoClientCert"},
+		{config: "tls_server_config:\n  cert_file: CERT\n  key_file: KEY\n  client_auth_type: RequireAndVerifyClientCert\n", err: "client_auth_type RequireAndVerifyClientCert requires client_ca_file"},
+		{config: "tls_server_config:\n  cert_file: CERT\n  key_file: KEY\n  client_auth_type: VerifyClientCertIfGiven\n", err: "client_auth_type VerifyClientCertIfGiven requires client_ca_file"},
+		{config: "tls_server_config:\n  cert_file: CERT\n  key_file: KEY\n  client_auth_type: RequireAndVerifyClientCert\n  client_ca_file: CERT\n"},
+	} {
+		writeFile(t, configPath, files.Replace(tc.config))
+		c, err := getConfig(configPath)
+		if err == nil {
+			_, err = newTLSConfig(&c.TLSConfig)
+		}
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Expected error containing %q, got %v", tc.err, err)
+		}
+	}
+
+	writeFile(t, configPath, fmt.Sprintf("tls_server_config:\n  cert_file: %s\n  key_file: %s\n", certFile, keyFile))
+	c, err := getConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := newTLSConfig(&c.TLSConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM([]byte(readFile(t, certFile)))
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error connecting with TLS: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func readFile(t *testing.T, path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}